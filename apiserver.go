@@ -0,0 +1,443 @@
+package main
+
+import (
+	"dnd/creature"
+	"dnd/dice"
+	"dnd/party"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// apiPartiesPrefix is where each party's API lives, under its name
+const apiPartiesPrefix = "/api/v1/parties/"
+
+// APIServer is the JSON API, for scripting and for bots. It makes the same actions as the pages
+// do, so everything done through it can be undone and shows up in the session log.
+type APIServer struct{}
+
+// apiError is an error with the status code to send back for it
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+func badRequest(format string, a ...interface{}) *apiError {
+	return &apiError{http.StatusBadRequest, fmt.Sprintf(format, a...)}
+}
+
+// unprocessable is for requests that make sense, but not for the party as it is now
+func unprocessable(format string, a ...interface{}) *apiError {
+	return &apiError{http.StatusUnprocessableEntity, fmt.Sprintf(format, a...)}
+}
+
+func notFound(format string, a ...interface{}) *apiError {
+	return &apiError{http.StatusNotFound, fmt.Sprintf(format, a...)}
+}
+
+func methodNotAllowed(r *http.Request) *apiError {
+	return &apiError{http.StatusMethodNotAllowed,
+		fmt.Sprintf("can't %s '%s'", r.Method, r.URL.Path)}
+}
+
+// apiPartyError is the error for a party that couldn't be loaded
+func apiPartyError(name string, err error) *apiError {
+	if err == party.ErrNoSuchParty {
+		return notFound("no party called '%s'", name)
+	}
+	return &apiError{http.StatusInternalServerError, err.Error()}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if body == nil {
+		return
+	}
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		log.Printf("Error writing API response - %v", err)
+	}
+}
+
+// writeAPIError sends an error as {"error": "..."}. Errors that aren't apiErrors are the
+// server's fault.
+func writeAPIError(w http.ResponseWriter, err error) {
+	e, ok := err.(*apiError)
+	if !ok {
+		e = &apiError{http.StatusInternalServerError, err.Error()}
+	}
+	writeJSON(w, e.status, map[string]string{"error": e.message})
+}
+
+func readJSON(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err != nil {
+		return badRequest("couldn't read request - %v", err)
+	}
+	return nil
+}
+
+type apiParty struct {
+	Name    string `json:"name"`
+	CanUndo bool   `json:"canUndo"`
+	CanRedo bool   `json:"canRedo"`
+}
+
+type apiCreature struct {
+	ID          string `json:"id"`
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	HitDice     string `json:"hitDice"`
+	Health      int    `json:"health"`
+	MaxHealth   int    `json:"maxHealth"`
+	DamageTaken int    `json:"damageTaken"`
+}
+
+type apiNewCreature struct {
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	HitDice string `json:"hitDice"`
+}
+
+type apiDamage struct {
+	Amount int `json:"amount"`
+}
+
+// apiInitiative is a player's initiative, or a creature's. Initiative is null for a player
+// who hasn't got one yet.
+type apiInitiative struct {
+	Name       string `json:"name"`
+	Initiative *int   `json:"initiative"`
+}
+
+type apiTurn struct {
+	Name       string `json:"name"`
+	Initiative int    `json:"initiative"`
+	Current    bool   `json:"current"`
+}
+
+type apiInitiatives struct {
+	Players   []apiInitiative `json:"players"`
+	TurnOrder []apiTurn       `json:"turnOrder"`
+}
+
+// apiInitiativeChange sets the initiatives of the players named in it, leaving everyone else's
+// alone, and can add a player and a creature
+type apiInitiativeChange struct {
+	Players     []apiInitiative   `json:"players"`
+	NewPlayer   *apiInitiative    `json:"newPlayer"`
+	NewCreature *apiNewInitiative `json:"newCreature"`
+}
+
+type apiNewInitiative struct {
+	Name           string `json:"name"`
+	InitiativeDice string `json:"initiativeDice"`
+}
+
+type apiRoll struct {
+	ID         int    `json:"id"`
+	Roll       string `json:"roll"`
+	Rolls      string `json:"rolls"`
+	Sum        int    `json:"sum"`
+	Visibility string `json:"visibility"`
+	Player     string `json:"player,omitempty"`
+	ChatAuthor string `json:"chatAuthor,omitempty"`
+	Label      string `json:"label,omitempty"`
+}
+
+type apiNewRoll struct {
+	Roll       string `json:"roll"`
+	Visibility string `json:"visibility"`
+}
+
+func creatureForAPI(c *creature.Creature) *apiCreature {
+	return &apiCreature{c.ID, c.Type.Name, c.Name, c.Type.HitDice.Expression(),
+		c.RolledHealth - c.DamageTaken, c.RolledHealth, c.DamageTaken}
+}
+
+func creaturesForAPI(p party.Party) []*apiCreature {
+	creatures := p.Creatures()
+	result := make([]*apiCreature, len(creatures))
+	for i, c := range creatures {
+		result[i] = creatureForAPI(c)
+	}
+	return result
+}
+
+func initiativesForAPI(p party.Party) *apiInitiatives {
+	data := &apiInitiatives{[]apiInitiative{}, []apiTurn{}}
+	for _, pi := range p.PlayerInitiatives() {
+		initiative := apiInitiative{pi.Name, nil}
+		if pi.HasInitiative {
+			value := pi.Initiative
+			initiative.Initiative = &value
+		}
+		data.Players = append(data.Players, initiative)
+	}
+	for _, t := range turnOrderInformation(p) {
+		data.TurnOrder = append(data.TurnOrder, apiTurn{t.Name, t.Initiative, t.Current})
+	}
+	return data
+}
+
+func rollsForAPI(rolls []*party.RecordedRoll) []*apiRoll {
+	result := make([]*apiRoll, len(rolls))
+	for i, r := range rolls {
+		result[i] = &apiRoll{r.ID, r.Roll.Expression(), r.StringIndividualRolls(), r.Sum,
+			r.Visibility.String(), r.Player, r.ChatAuthor, r.Label}
+	}
+	return result
+}
+
+// checkCreature checks the creature with the ID in the URL is there
+func checkCreature(p party.Party, ID string) error {
+	if p.Creature(ID) == nil {
+		return notFound("no creature '%s'", ID)
+	}
+	return nil
+}
+
+// apply applies an action and saves the party, as the pages do. Actions only fail to apply when
+// they don't make sense for the party, which is the request's fault, not the server's.
+func (s *APIServer) apply(p party.Party, action party.ReversibleAction) error {
+	err := p.Apply(action)
+	if err != nil {
+		return unprocessable("%v", err)
+	}
+	s.save(p)
+	return nil
+}
+
+func (s *APIServer) save(p party.Party) {
+	err := p.Save()
+	if err != nil {
+		log.Printf("Error saving party '%s' - %v", p.Name(), err)
+	}
+}
+
+func (s *APIServer) addCreature(r *http.Request, p party.Party) (int, interface{}, error) {
+	var c apiNewCreature
+	err := readJSON(r, &c)
+	if err != nil {
+		return 0, nil, err
+	}
+	roll, err := dice.ParseRollString(c.HitDice)
+	if err != nil {
+		return 0, nil, badRequest("invalid hit dice '%s' - %v", c.HitDice, err)
+	}
+	added := creature.Create(c.Type, c.Name, roll)
+	err = s.apply(p, &party.AddCreatureAction{added})
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusCreated, creatureForAPI(added), nil
+}
+
+func (s *APIServer) damageCreature(r *http.Request, p party.Party, ID string) (int, interface{}, error) {
+	var d apiDamage
+	err := readJSON(r, &d)
+	if err != nil {
+		return 0, nil, err
+	}
+	err = s.apply(p, &party.DamageCreatureAction{ID, d.Amount})
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, creatureForAPI(p.Creature(ID)), nil
+}
+
+func (s *APIServer) setInitiatives(r *http.Request, p party.Party) (int, interface{}, error) {
+	var c apiInitiativeChange
+	err := readJSON(r, &c)
+	if err != nil {
+		return 0, nil, err
+	}
+	pis := p.PlayerInitiatives()
+	change := party.InitiativeChange{Players: pis}
+	for _, set := range c.Players {
+		found := false
+		for _, pi := range pis {
+			if pi.Name == set.Name {
+				pi.HasInitiative = set.Initiative != nil
+				if set.Initiative != nil {
+					pi.Initiative = *set.Initiative
+				}
+				found = true
+			}
+		}
+		if !found {
+			return 0, nil, badRequest("there's no player called '%s'", set.Name)
+		}
+	}
+	if c.NewPlayer != nil {
+		if strings.TrimSpace(c.NewPlayer.Name) == "" {
+			return 0, nil, badRequest("the new player needs a name")
+		}
+		change.NewPlayer = &party.CreatureInitiative{c.NewPlayer.Name, c.NewPlayer.Initiative != nil, 0}
+		if c.NewPlayer.Initiative != nil {
+			change.NewPlayer.Initiative = *c.NewPlayer.Initiative
+		}
+	}
+	if c.NewCreature != nil {
+		if strings.TrimSpace(c.NewCreature.Name) == "" {
+			return 0, nil, badRequest("the new creature needs a name")
+		}
+		initiative := c.NewCreature.InitiativeDice
+		if initiative == "" {
+			initiative = "d20"
+		}
+		roll, err := dice.ParseRollString(initiative)
+		if err != nil {
+			return 0, nil, badRequest("invalid initiative dice '%s' - %v", initiative, err)
+		}
+		change.NewCreature = &party.EncounterCreature{c.NewCreature.Name, *roll, roll.Simulate().Sum}
+	}
+	action, err := p.InitiativeAction(change)
+	if err != nil {
+		return 0, nil, badRequest("%v", err)
+	}
+	err = s.apply(p, action)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, initiativesForAPI(p), nil
+}
+
+func (s *APIServer) roll(r *http.Request, p party.Party) (int, interface{}, error) {
+	var n apiNewRoll
+	err := readJSON(r, &n)
+	if err != nil {
+		return 0, nil, err
+	}
+	visibility := party.PublicRoll
+	if n.Visibility != "" {
+		visibility, err = party.ParseRollVisibility(n.Visibility)
+		if err != nil {
+			return 0, nil, badRequest("%v", err)
+		}
+	}
+	roll, err := dice.ParseRollString(n.Roll)
+	if err != nil {
+		return 0, nil, badRequest("invalid roll '%s' - %v", n.Roll, err)
+	}
+	p.AddRoll(roll.Simulate(), visibility)
+	s.save(p)
+	// Posts to a party hold its write lock, so the newest roll is this one
+	return http.StatusCreated, rollsForAPI(p.RecordedRolls()[:1])[0], nil
+}
+
+func (s *APIServer) undoOrRedo(p party.Party, redo bool) (int, interface{}, error) {
+	var err error
+	if redo {
+		if !p.CanRedo() {
+			return 0, nil, &apiError{http.StatusConflict, "there's nothing to redo"}
+		}
+		err = p.Redo()
+	} else {
+		if !p.CanUndo() {
+			return 0, nil, &apiError{http.StatusConflict, "there's nothing to undo"}
+		}
+		err = p.Undo()
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	s.save(p)
+	return http.StatusOK, &apiParty{p.Name(), p.CanUndo(), p.CanRedo()}, nil
+}
+
+// serve works out what a request to a party's API is for. The paths are:
+// GET / - the party
+// GET, POST /creatures - the creatures in the encounter, or add one
+// DELETE /creatures/(ID) - remove a creature
+// POST /creatures/(ID)/damage - damage a creature, or heal it with a negative amount
+// GET, POST /initiative - the initiatives and turn order, or set them
+// POST /initiative/next-turn - move on to the next turn
+// GET, POST /rolls - the rolls, most recent first, or roll some dice
+// POST /undo, /redo
+func (s *APIServer) serve(r *http.Request, p party.Party) (int, interface{}, error) {
+	path := strings.Trim(r.URL.Path, "/")
+	parts := strings.Split(path, "/")
+	get, post := r.Method == "GET", r.Method == "POST"
+	switch {
+	case path == "" && get:
+		return http.StatusOK, &apiParty{p.Name(), p.CanUndo(), p.CanRedo()}, nil
+	case path == "creatures" && get:
+		return http.StatusOK, creaturesForAPI(p), nil
+	case path == "creatures" && post:
+		return s.addCreature(r, p)
+	case parts[0] == "creatures" && len(parts) == 2 && r.Method == "DELETE":
+		if err := checkCreature(p, parts[1]); err != nil {
+			return 0, nil, err
+		}
+		return http.StatusNoContent, nil, s.apply(p, p.DeleteCreatureAction(parts[1]))
+	case parts[0] == "creatures" && len(parts) == 3 && parts[2] == "damage" && post:
+		if err := checkCreature(p, parts[1]); err != nil {
+			return 0, nil, err
+		}
+		return s.damageCreature(r, p, parts[1])
+	case path == "initiative" && get:
+		return http.StatusOK, initiativesForAPI(p), nil
+	case path == "initiative" && post:
+		return s.setInitiatives(r, p)
+	case path == "initiative/next-turn" && post:
+		err := s.apply(p, &party.NextTurnAction{})
+		if err != nil {
+			return 0, nil, err
+		}
+		return http.StatusOK, initiativesForAPI(p), nil
+	case path == "rolls" && get:
+		return http.StatusOK, rollsForAPI(p.RecordedRolls()), nil
+	case path == "rolls" && post:
+		return s.roll(r, p)
+	case (path == "undo" || path == "redo") && post:
+		return s.undoOrRedo(p, path == "redo")
+	}
+	switch path {
+	case "", "creatures", "initiative", "initiative/next-turn", "rolls", "undo", "redo":
+		return 0, nil, methodNotAllowed(r)
+	}
+	return 0, nil, notFound("nothing at '%s'", r.URL.Path)
+}
+
+// handler serves the API of one party
+func (s *APIServer) handler(p party.Party) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, body, err := s.serve(r, p)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		writeJSON(w, status, body)
+	})
+}
+
+// ServeParties lists the parties, at /api/v1/parties. Anything else under /api/v1/ that isn't a
+// party's API isn't there.
+func (s *APIServer) ServeParties(store party.Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/parties" {
+			writeAPIError(w, notFound("nothing at '%s'", r.URL.Path))
+			return
+		}
+		if r.Method != "GET" {
+			writeAPIError(w, methodNotAllowed(r))
+			return
+		}
+		names, err := store.List()
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, names)
+	})
+}
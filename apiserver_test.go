@@ -0,0 +1,89 @@
+package main
+
+import (
+	"dnd/party"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPI(t *testing.T) {
+	store := party.NewMemoryStore()
+	is, err := newInitialisationServer(store, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, is.createParty("heroes"))
+	heroes, _ := is.Party("heroes")
+	heroes.Apply(&party.AddPlayerAction{"Anya"})
+	router := newPartyRouter(is, &partyServers{api: &APIServer{}})
+	call := func(method, path, body string, v interface{}) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, apiPartiesPrefix+path, strings.NewReader(body)))
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		if v != nil {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), v))
+		}
+		return w.Code
+	}
+
+	var c apiCreature
+	assert.Equal(t, http.StatusCreated, call("POST", "heroes/creatures",
+		`{"type": "Goblin", "name": "Snark", "hitDice": "2d6"}`, &c))
+	assert.Equal(t, "Snark", c.Name)
+	assert.NotEmpty(t, c.ID)
+	assert.Equal(t, http.StatusOK, call("POST", "heroes/creatures/"+c.ID+"/damage", `{"amount": 1}`, &c))
+	assert.Equal(t, 1, c.DamageTaken)
+	var creatures []*apiCreature
+	assert.Equal(t, http.StatusOK, call("GET", "heroes/creatures", "", &creatures))
+	assert.Len(t, creatures, 1)
+
+	var e map[string]string
+	assert.Equal(t, http.StatusBadRequest, call("POST", "heroes/creatures",
+		`{"type": "Goblin", "name": "Snark", "hitDice": "2x6"}`, &e))
+	assert.Contains(t, e["error"], "2x6")
+	assert.Equal(t, http.StatusBadRequest, call("POST", "heroes/creatures/"+c.ID+"/damage", `{"amount": "lots"}`, nil))
+	assert.Equal(t, http.StatusNotFound, call("POST", "heroes/creatures/0bad1d/damage", `{"amount": 1}`, nil))
+	// Actions the party turns down are the request's fault
+	w := httptest.NewRecorder()
+	writeAPIError(w, (&APIServer{}).apply(heroes, &party.DamageCreatureAction{"0bad1d", 1}))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, http.StatusNotFound, call("GET", "villains/creatures", "", nil))
+	assert.Equal(t, http.StatusNotFound, call("GET", "heroes/monsters", "", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, call("PUT", "heroes/creatures", "", nil))
+
+	var initiatives apiInitiatives
+	assert.Equal(t, http.StatusOK, call("POST", "heroes/initiative",
+		`{"players": [{"name": "Anya", "initiative": 12}], "newCreature": {"name": "Snark", "initiativeDice": "20"}}`,
+		&initiatives))
+	assert.Equal(t, 12, *initiatives.Players[0].Initiative)
+	assert.Equal(t, "Snark", initiatives.TurnOrder[0].Name)
+	assert.True(t, initiatives.TurnOrder[0].Current)
+	assert.Equal(t, http.StatusOK, call("POST", "heroes/initiative/next-turn", "", &initiatives))
+	assert.True(t, initiatives.TurnOrder[1].Current)
+	assert.Equal(t, http.StatusBadRequest, call("POST", "heroes/initiative",
+		`{"players": [{"name": "Boris", "initiative": 3}]}`, nil))
+
+	var roll apiRoll
+	assert.Equal(t, http.StatusCreated, call("POST", "heroes/rolls", `{"roll": "5", "visibility": "dm"}`, &roll))
+	assert.Equal(t, 5, roll.Sum)
+	assert.Equal(t, "dm", roll.Visibility)
+	assert.Equal(t, http.StatusBadRequest, call("POST", "heroes/rolls", `{"roll": "5", "visibility": "tv"}`, nil))
+
+	assert.Equal(t, http.StatusNoContent, call("DELETE", "heroes/creatures/"+c.ID, "", nil))
+	assert.Empty(t, heroes.Creatures())
+	var p apiParty
+	assert.Equal(t, http.StatusOK, call("POST", "heroes/undo", "", &p))
+	assert.True(t, p.CanRedo)
+	assert.Len(t, heroes.Creatures(), 1)
+	assert.Equal(t, http.StatusOK, call("POST", "heroes/redo", "", nil))
+	assert.Equal(t, http.StatusConflict, call("POST", "heroes/redo", "", nil))
+
+	w = httptest.NewRecorder()
+	(&APIServer{}).ServeParties(store).ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/parties", nil))
+	assert.Equal(t, "[\"heroes\"]\n", w.Body.String())
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"log"
+	"os"
+)
+
+// The stylesheets in static are compiled from the Sass, and committed so that building doesn't
+// need Sass. Run go generate after changing the Sass, or watch-for-sass.sh while working on it.
+//go:generate sass --no-source-map sass:static
+
+// The templates and static files are built into the binary, so that it works wherever it's
+// started from
+//
+//go:embed templates static
+var embeddedAssets embed.FS
+
+// subFS is the part of a file system in a directory. Only the embedded assets are split up, so
+// the directory is always there.
+func subFS(fsys fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		log.Fatalf("No '%s' in the assets - %v", dir, err)
+	}
+	return sub
+}
+
+// useAssets picks where the templates and static files come from: what's built into the binary,
+// or in dev mode the configured directories, so that changes to them show up without rebuilding.
+func useAssets(c config) (templates, static fs.FS) {
+	if !c.Dev {
+		return subFS(embeddedAssets, "templates"), subFS(embeddedAssets, "static")
+	}
+	log.Printf("Dev mode: using templates from '%s' and static files from '%s'", c.TemplateDir,
+		c.StaticDir)
+	return os.DirFS(c.TemplateDir), os.DirFS(c.StaticDir)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"io/fs"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStylesheetsBuiltIn(t *testing.T) {
+	templates, static := useAssets(defaultConfig())
+	frame, err := fs.ReadFile(templates, "frame.html.tmpl")
+	assert.NoError(t, err)
+	links := regexp.MustCompile(`href="/static/([^"]+\.css)"`).FindAllStringSubmatch(string(frame), -1)
+	assert.NotEmpty(t, links)
+	for _, link := range links {
+		_, err := fs.Stat(static, link[1])
+		assert.NoError(t, err, "%s is missing - run go generate", link[1])
+	}
+	// It has the newest styles, for form errors, so it was compiled from the current Sass
+	style, err := fs.ReadFile(static, "style-all.css")
+	assert.NoError(t, err)
+	assert.Contains(t, string(style), "span.field-error")
+}
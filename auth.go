@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"dnd/party"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dmAuth keeps the DM's password, hashed in the data directory, and who's signed in as the DM.
+// Players don't sign in: the phone and player view pages, and the event stream that refreshes
+// them, are open to anyone who can reach the server.
+type dmAuth struct {
+	templates *templateSet
+	hash      []byte
+	mutex     sync.Mutex
+	sessions  map[string]*dmSession
+}
+
+// dmSession is one browser signed in as the DM. Every form it posts has to carry its CSRF
+// token, so that other sites can't post to the DM's pages using the DM's cookie.
+type dmSession struct {
+	csrfToken string
+	expires   time.Time
+}
+
+const (
+	dmPasswordFile  = "dm-password.bcrypt"
+	dmSessionCookie = "dm-session"
+	dmSessionAge    = 7 * 24 * time.Hour
+	csrfTokenField  = "csrfToken"
+	csrfTokenHeader = "X-CSRF-Token"
+)
+
+func randomToken(length int) string {
+	b := make([]byte, length)
+	_, err := rand.Read(b)
+	if err != nil {
+		// The system's random number generator is broken, and nothing's going to work anyway
+		log.Panicf("Error generating token - %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// newDMAuth loads the DM's password hash from the data directory. If a password is given it
+// replaces the stored one, and if there's neither, one is made up and logged.
+func newDMAuth(dataDir, password string, templates *templateSet) (*dmAuth, error) {
+	filename := filepath.Join(dataDir, dmPasswordFile)
+	hash, err := ioutil.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading DM password - %v", err)
+	}
+	hash = []byte(strings.TrimSpace(string(hash)))
+	if password == "" && len(hash) == 0 {
+		password = randomToken(6)
+		log.Printf("No DM password set, so it's '%s' - set DND_DM_PASSWORD or -dm-password-file "+
+			"to choose another", password)
+	}
+	if password != "" && bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		hash, err = bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, fmt.Errorf("error hashing DM password - %v", err)
+		}
+		err = party.WriteFileAtomically(filename, append(hash, '\n'), 0600)
+		if err != nil {
+			return nil, fmt.Errorf("error saving DM password - %v", err)
+		}
+	}
+	return &dmAuth{templates: templates, hash: hash, sessions: make(map[string]*dmSession)}, nil
+}
+
+func (a *dmAuth) checkPassword(password string) bool {
+	return bcrypt.CompareHashAndPassword(a.hash, []byte(password)) == nil
+}
+
+// session returns the DM session a request's cookie belongs to, or nil if it hasn't got one
+func (a *dmAuth) session(r *http.Request) *dmSession {
+	cookie, err := r.Cookie(dmSessionCookie)
+	if err != nil {
+		return nil
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	s, ok := a.sessions[cookie.Value]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(s.expires) {
+		delete(a.sessions, cookie.Value)
+		return nil
+	}
+	return s
+}
+
+func (a *dmAuth) startSession(w http.ResponseWriter, r *http.Request) {
+	token := randomToken(32)
+	a.mutex.Lock()
+	a.sessions[token] = &dmSession{randomToken(32), time.Now().Add(dmSessionAge)}
+	a.mutex.Unlock()
+	setDMSessionCookie(w, r, token, dmSessionAge)
+}
+
+func (a *dmAuth) endSession(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(dmSessionCookie); err == nil {
+		a.mutex.Lock()
+		delete(a.sessions, cookie.Value)
+		a.mutex.Unlock()
+	}
+	setDMSessionCookie(w, r, "", -time.Second)
+}
+
+// setDMSessionCookie sets the session cookie, or clears it with a negative age. Over HTTPS it's
+// only ever sent back over HTTPS, so that it can't be picked up from a plain HTTP request.
+func setDMSessionCookie(w http.ResponseWriter, r *http.Request, value string, age time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     dmSessionCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(age.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// csrfToken is the CSRF token of the DM session a request comes from
+func csrfToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfTokenKey).(string)
+	return token
+}
+
+// playerPath is whether a URL is one players can use without being the DM
+func playerPath(path string) bool {
+	if path == "/login" || path == "/favicon.ico" || strings.HasPrefix(path, "/static/") {
+		return true
+	}
+	if !strings.HasPrefix(path, "/p/") {
+		return false
+	}
+	rest := strings.TrimPrefix(path, "/p/")
+	slash := strings.Index(rest, "/")
+	if slash == -1 {
+		return false
+	}
+	rest = rest[slash:]
+	return rest == "/events" || strings.HasPrefix(rest, "/phone/") || strings.HasPrefix(rest, "/players/")
+}
+
+// bearerPassword is the password given in an Authorization header, for scripts and the API that
+// don't keep cookies. Those requests don't need a CSRF token, as browsers don't add the header themselves.
+func bearerPassword(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", false
+	}
+	return strings.TrimPrefix(header, "Bearer "), true
+}
+
+// handler only lets the DM through to the DM's pages, and checks the CSRF token on the DM's posts
+func (a *dmAuth) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if playerPath(r.URL.Path) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if password, ok := bearerPassword(r); ok {
+			if !a.checkPassword(password) {
+				http.Error(w, "Wrong DM password", http.StatusUnauthorized)
+				return
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+		s := a.session(r)
+		if s == nil {
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				writeAPIError(w, &apiError{http.StatusUnauthorized, "only the DM can use the API"})
+				return
+			}
+			if r.Method != "GET" && r.Method != "HEAD" {
+				http.Error(w, "Only the DM can do that", http.StatusForbidden)
+				return
+			}
+			http.Redirect(w, r, "/login?next="+url.QueryEscape(r.RequestURI), http.StatusSeeOther)
+			return
+		}
+		if r.Method != "GET" && r.Method != "HEAD" {
+			token := r.Header.Get(csrfTokenHeader)
+			if token == "" {
+				if err := parseForm(r); err != nil {
+					http.Error(w, fmt.Sprintf("Couldn't read form - %v", err), http.StatusBadRequest)
+					return
+				}
+				token = r.Form.Get(csrfTokenField)
+			}
+			if subtle.ConstantTimeCompare([]byte(token), []byte(s.csrfToken)) != 1 {
+				http.Error(w, "Missing or wrong CSRF token", http.StatusForbidden)
+				return
+			}
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), csrfTokenKey, s.csrfToken)))
+	})
+}
+
+type loginTemplateData struct {
+	Next   string
+	Failed bool
+}
+
+// GetTemplate gets the template
+func (a *dmAuth) GetTemplate() *template.Template {
+	return a.templates.page("login.html")
+}
+
+// GenerateTemplateData says where to go after signing in, and whether the last try failed
+func (a *dmAuth) GenerateTemplateData(r *http.Request) interface{} {
+	return &loginTemplateData{localURI(r.URL.Query().Get("next")), r.URL.Query().Get("failed") != ""}
+}
+
+// localURI stops the login page sending people off to other sites afterwards
+func localURI(uri string) string {
+	if !strings.HasPrefix(uri, "/") || strings.HasPrefix(uri, "//") {
+		return "/"
+	}
+	return uri
+}
+
+// ServeLogin shows the login page, and signs the DM in when the right password is posted to it
+func (a *dmAuth) ServeLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		(&standardTemplatedGetHandler{a}).ServeHTTP(w, r)
+		return
+	}
+	next := localURI(r.PostFormValue("next"))
+	if !a.checkPassword(r.PostFormValue("password")) {
+		log.Printf("Wrong DM password from %s", r.RemoteAddr)
+		http.Redirect(w, r, "/login?failed=1&next="+url.QueryEscape(next), http.StatusSeeOther)
+		return
+	}
+	a.startSession(w, r)
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// ServeLogout signs the DM out. It's behind handler, so it needs the CSRF token like any post.
+func (a *dmAuth) ServeLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	a.endSession(w, r)
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
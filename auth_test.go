@@ -0,0 +1,138 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDMPassword(t *testing.T) {
+	d, err := ioutil.TempDir("", "encounters")
+	if err != nil {
+		t.Fatalf("Couldn't create temporary directory - %v", err)
+	}
+	defer os.RemoveAll(d)
+
+	a, err := newDMAuth(d, "hunter2", nil)
+	assert.NoError(t, err)
+	assert.True(t, a.checkPassword("hunter2"))
+	assert.False(t, a.checkPassword("hunter3"))
+	stored, _ := ioutil.ReadFile(filepath.Join(d, dmPasswordFile))
+	assert.NotContains(t, string(stored), "hunter2")
+
+	// With no password given, the stored one is kept
+	a, err = newDMAuth(d, "", nil)
+	assert.NoError(t, err)
+	assert.True(t, a.checkPassword("hunter2"))
+
+	a, err = newDMAuth(d, "swordfish", nil)
+	assert.NoError(t, err)
+	assert.False(t, a.checkPassword("hunter2"))
+	assert.True(t, a.checkPassword("swordfish"))
+}
+
+func TestDMOnlyPages(t *testing.T) {
+	d, err := ioutil.TempDir("", "encounters")
+	if err != nil {
+		t.Fatalf("Couldn't create temporary directory - %v", err)
+	}
+	defer os.RemoveAll(d)
+	a, err := newDMAuth(d, "hunter2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", a.ServeLogin)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("token " + csrfToken(r)))
+	})
+	h := a.handler(mux)
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	login := func(password string) *httptest.ResponseRecorder {
+		form := url.Values{"password": {password}, "next": {"/p/heroes/"}}
+		r := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return serve(r)
+	}
+	post := func(path string, form url.Values, cookie *http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		return serve(r)
+	}
+
+	w := serve(httptest.NewRequest("GET", "/p/heroes/", nil))
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/login?next=%2Fp%2Fheroes%2F", w.Header().Get("Location"))
+	assert.Equal(t, http.StatusForbidden, post("/p/heroes/encounter/", url.Values{}, nil).Code)
+
+	// Players can get to their own pages without logging in
+	assert.Equal(t, http.StatusOK, serve(httptest.NewRequest("GET", "/p/heroes/players/", nil)).Code)
+	assert.Equal(t, http.StatusOK, post("/p/heroes/phone/join", url.Values{}, nil).Code)
+	assert.Equal(t, http.StatusOK, serve(httptest.NewRequest("GET", "/p/heroes/events", nil)).Code)
+
+	w = login("hunter3")
+	assert.Equal(t, "/login?failed=1&next=%2Fp%2Fheroes%2F", w.Header().Get("Location"))
+	assert.Empty(t, w.Result().Cookies())
+
+	w = login("hunter2")
+	assert.Equal(t, "/p/heroes/", w.Header().Get("Location"))
+	cookies := w.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	session := cookies[0]
+	assert.False(t, session.Secure)
+
+	r := httptest.NewRequest("GET", "/p/heroes/", nil)
+	r.AddCookie(session)
+	w = serve(r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	token := strings.TrimPrefix(w.Body.String(), "token ")
+	assert.NotEmpty(t, token)
+
+	assert.Equal(t, http.StatusForbidden, post("/p/heroes/encounter/", url.Values{}, session).Code)
+	assert.Equal(t, http.StatusForbidden,
+		post("/p/heroes/encounter/", url.Values{csrfTokenField: {"nope"}}, session).Code)
+	assert.Equal(t, http.StatusOK,
+		post("/p/heroes/encounter/", url.Values{csrfTokenField: {token}}, session).Code)
+
+	// Scripts can give the password instead
+	r = httptest.NewRequest("POST", "/p/heroes/encounter/", nil)
+	r.Header.Set("Authorization", "Bearer hunter2")
+	assert.Equal(t, http.StatusOK, serve(r).Code)
+	r = httptest.NewRequest("POST", "/p/heroes/encounter/", nil)
+	r.Header.Set("Authorization", "Bearer hunter3")
+	assert.Equal(t, http.StatusUnauthorized, serve(r).Code)
+
+	// Over HTTPS, the cookie is only sent back over HTTPS, and so is the one clearing it
+	form := url.Values{"password": {"hunter2"}, "next": {"/"}}
+	r = httptest.NewRequest("POST", "https://dnd.example.com/login",
+		strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = serve(r)
+	cookies = w.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	assert.True(t, cookies[0].Secure)
+	w = httptest.NewRecorder()
+	a.endSession(w, httptest.NewRequest("POST", "https://dnd.example.com/logout", nil))
+	cookies = w.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	assert.True(t, cookies[0].Secure)
+	assert.Empty(t, cookies[0].Value)
+
+	// Going somewhere else after logging in is only allowed on this site
+	assert.Equal(t, "/", localURI("//evil.example.com/"))
+	assert.Equal(t, "/", localURI("https://evil.example.com/"))
+}
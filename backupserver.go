@@ -0,0 +1,83 @@
+package main
+
+import (
+	"dnd/party"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// BackupServer lists the backups of the party, and restores them
+type BackupServer struct {
+	templates *templateSet
+	store     party.Store
+}
+
+type backupInformation struct {
+	Time, Kind, RestoreURL string
+}
+
+type backupTemplateData struct {
+	PartyName string
+	Backups   []*backupInformation
+}
+
+// GetTemplate gets the template
+func (s *BackupServer) GetTemplate() *template.Template {
+	return s.templates.page("backups.html")
+}
+
+// GenerateTemplateData lists the backups, newest first
+func (s *BackupServer) GenerateTemplateData(r *http.Request, p party.Party) interface{} {
+	data := &backupTemplateData{p.Name(), make([]*backupInformation, 0)}
+	store, ok := s.store.(party.BackupStore)
+	if !ok {
+		return data
+	}
+	backups, err := store.Backups(p.Name())
+	if err != nil {
+		log.Printf("Error listing backups - %v", err)
+		return data
+	}
+	for _, b := range backups {
+		kind := "Session"
+		if b.Daily {
+			kind = "Daily"
+		}
+		data.Backups = append(data.Backups, &backupInformation{
+			b.Time.Format("2006-01-02 15:04:05"), kind, "/backups/restore/" + b.Name})
+	}
+	return data
+}
+
+// HandlePost restores the backup named in the URL /backups/restore/(backup)
+func (s *BackupServer) HandlePost(r *http.Request, p party.Party) (party.ReversibleAction, error) {
+	if !strings.HasPrefix(r.URL.Path, "/backups/restore/") {
+		return nil, fmt.Errorf("unrecognised endpoint: '%v'", r.URL.Path)
+	}
+	store, ok := s.store.(party.BackupStore)
+	if !ok {
+		return nil, errors.New("parties aren't being backed up")
+	}
+	name, err := getURLArgument(r.URL)
+	if err != nil {
+		return nil, err
+	}
+	backups, err := store.Backups(p.Name())
+	if err != nil {
+		return nil, fmt.Errorf("error listing backups - %v", err)
+	}
+	for _, b := range backups {
+		if b.Name == name {
+			backup, err := store.LoadBackup(p.Name(), name)
+			if err != nil {
+				return nil, fmt.Errorf("error loading backup - %v", err)
+			}
+			return party.NewRestoreAction(backup, b.Time), nil
+		}
+	}
+	return nil, fmt.Errorf("no backup called '%s'", name)
+}
@@ -0,0 +1,143 @@
+// Package chat lets people roll dice from a group chat, like Discord, for players who aren't at
+// the table. Rolls are recorded in the party just like those made on the web pages, so the
+// overview and the chat show the same rolls. Each chat service plugs in as a Transport.
+package chat
+
+import (
+	"dnd/dice"
+	"dnd/party"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Message is something said in a chat
+type Message struct {
+	// Channel is where it was said, which is where the reply goes
+	Channel, Author, Text string
+}
+
+// Transport connects the bot to a chat service
+type Transport interface {
+	// Messages are the messages said in the chat. It's closed when the transport is.
+	Messages() <-chan Message
+	// Send says something in a channel
+	Send(channel, text string) error
+}
+
+// Parties finds parties by name. The web server's parties are one, so that the bot uses the same
+// parties as the pages, and doesn't change a party while a page is.
+type Parties interface {
+	Party(name string) (party.Party, error)
+	// Change calls change with a party, while nothing else changes it
+	Change(name string, change func(party.Party)) error
+}
+
+// Bot answers commands in a chat, like "/roll 1d20+5 stealth". Rolls go in the active party.
+type Bot struct {
+	transport Transport
+	parties   Parties
+	active    string
+}
+
+// NewBot creates a bot for a chat, with rolls going in a party to start with
+func NewBot(transport Transport, parties Parties, active string) *Bot {
+	return &Bot{transport, parties, active}
+}
+
+const help = "/roll <dice> [what for], e.g. /roll 1d20+5 stealth: roll some dice\n" +
+	"/party [name]: show or change the party rolls go in"
+
+// Run answers commands until the transport is closed
+func (b *Bot) Run() {
+	for m := range b.transport.Messages() {
+		reply := b.safelyHandle(m)
+		if reply == "" {
+			continue
+		}
+		err := b.transport.Send(m.Channel, reply)
+		if err != nil {
+			log.Printf("Error replying in chat channel '%s' - %v", m.Channel, err)
+		}
+	}
+}
+
+// safelyHandle answers a message, so that nothing anyone says can take the server down with the
+// bot
+func (b *Bot) safelyHandle(m Message) (reply string) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Error answering '%s' in chat channel '%s' - %v", m.Text, m.Channel, r)
+			reply = "Sorry, something went wrong"
+		}
+	}()
+	return b.handle(m)
+}
+
+// handle answers a message, returning the reply. Anything that isn't a command of the bot's gets
+// no reply, as it's just people talking, or for another bot.
+func (b *Bot) handle(m Message) string {
+	fields := strings.Fields(m.Text)
+	if len(fields) == 0 {
+		return ""
+	}
+	switch fields[0] {
+	case "/roll":
+		return b.roll(m.Author, fields[1:])
+	case "/party":
+		if len(fields) == 1 {
+			return "Rolls are going in " + b.active
+		}
+		name := strings.Join(fields[1:], " ")
+		_, err := b.parties.Party(name)
+		if err != nil {
+			return fmt.Sprintf("Can't use party '%s' - %v", name, err)
+		}
+		b.active = name
+		return "Rolls are going in " + name + " now"
+	case "/help":
+		return help
+	}
+	return ""
+}
+
+// parseRoll reads the dice at the start of a command, which may be in several words, like
+// "2d6 + 3 damage". The rest is what the roll is for.
+func parseRoll(words []string) (*dice.Roll, string, error) {
+	for i := len(words); i > 0; i-- {
+		roll, err := dice.ParseRollString(strings.Join(words[:i], " "))
+		if err == nil {
+			return roll, strings.Join(words[i:], " "), nil
+		}
+	}
+	return nil, "", fmt.Errorf("'%s' doesn't start with dice, like 1d20+5", strings.Join(words, " "))
+}
+
+// roll rolls dice for someone in the chat and records it in the active party, with who they are
+// in the chat and what it was for. Anyone can call themselves anything in a chat, so it's never
+// recorded as a player's roll.
+func (b *Bot) roll(author string, words []string) string {
+	if len(words) == 0 {
+		return "Roll what? Like /roll 1d20+5 stealth"
+	}
+	roll, label, err := parseRoll(words)
+	if err != nil {
+		return err.Error()
+	}
+	result := roll.Simulate()
+	err = b.parties.Change(b.active, func(p party.Party) {
+		p.AddChatRoll(author, label, result)
+		err := p.Save()
+		if err != nil {
+			log.Printf("Error saving party '%s' after a roll from chat - %v", b.active, err)
+		}
+	})
+	if err != nil {
+		return fmt.Sprintf("Can't roll in party '%s' - %v", b.active, err)
+	}
+	if label != "" {
+		label = " for " + label
+	}
+	return fmt.Sprintf("%s rolled %s%s: %s = %d", author, roll, label,
+		result.StringIndividualRolls(), result.Sum)
+}
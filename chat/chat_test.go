@@ -0,0 +1,122 @@
+package chat
+
+import (
+	"dnd/party"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testParties map[string]party.Party
+
+func (ps testParties) Party(name string) (party.Party, error) {
+	p, ok := ps[name]
+	if !ok {
+		return nil, party.ErrNoSuchParty
+	}
+	return p, nil
+}
+
+func (ps testParties) Change(name string, change func(party.Party)) error {
+	p, err := ps.Party(name)
+	if err != nil {
+		return err
+	}
+	change(p)
+	return nil
+}
+
+func testParty(t *testing.T, store party.Store, name string) party.Party {
+	assert.NoError(t, store.Save(party.New("", name)))
+	p, err := store.Load(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// converse runs a bot through a conversation, returning its replies
+func converse(bot *Bot, transport *FakeTransport, author string, lines ...string) []Message {
+	for _, l := range lines {
+		transport.Say("table", author, l)
+	}
+	transport.Close()
+	bot.Run()
+	return transport.Sent()
+}
+
+func TestRoll(t *testing.T) {
+	store := party.NewMemoryStore()
+	heroes := testParty(t, store, "heroes")
+	transport := NewFakeTransport()
+	bot := NewBot(transport, testParties{"heroes": heroes}, "heroes")
+
+	sent := converse(bot, transport, "Colin", "/roll 1d20+5 stealth", "just chatting", "/roll 3",
+		"/roll lots", "/roll")
+	assert.Len(t, sent, 4)
+	assert.Equal(t, "table", sent[0].Channel)
+	assert.True(t, strings.HasPrefix(sent[0].Text, "Colin rolled d20 + 5 for stealth: "))
+	assert.Equal(t, "Colin rolled 3: 3 = 3", sent[1].Text)
+
+	// The rolls are in the party, for the web pages
+	rolls := heroes.RecordedRolls()
+	assert.Len(t, rolls, 2)
+	assert.Equal(t, 3, rolls[0].Sum)
+	assert.Equal(t, "Colin", rolls[1].ChatAuthor)
+	assert.Equal(t, "stealth", rolls[1].Label)
+	assert.Equal(t, "Colin (chat)", rolls[1].Who())
+	saved, _ := store.Load("heroes")
+	assert.Len(t, saved.Rolls(), 2)
+	assert.Equal(t, "stealth", saved.RecordedRolls()[1].Label)
+}
+
+func TestChatNamesArentPlayers(t *testing.T) {
+	heroes := testParty(t, party.NewMemoryStore(), "heroes")
+	heroes.Apply(&party.AddPlayerAction{"Anya"})
+	transport := NewFakeTransport()
+	bot := NewBot(transport, testParties{"heroes": heroes}, "heroes")
+	// Anyone can call themselves Anya in a chat
+	converse(bot, transport, "Anya", "/roll d20")
+	roll := heroes.RecordedRolls()[0]
+	assert.Equal(t, "", roll.Player)
+	assert.Equal(t, "Anya", roll.ChatAuthor)
+}
+
+func TestChangeParty(t *testing.T) {
+	store := party.NewMemoryStore()
+	parties := testParties{"heroes": testParty(t, store, "heroes"),
+		"villains": testParty(t, store, "villains")}
+	transport := NewFakeTransport()
+	bot := NewBot(transport, parties, "heroes")
+	sent := converse(bot, transport, "DM", "/party", "/party nobody", "/party villains", "/roll 4")
+	assert.Equal(t, "Rolls are going in heroes", sent[0].Text)
+	assert.Contains(t, sent[1].Text, "Can't use party 'nobody'")
+	assert.Equal(t, "Rolls are going in villains now", sent[2].Text)
+	assert.Empty(t, parties["heroes"].Rolls())
+	assert.Len(t, parties["villains"].Rolls(), 1)
+}
+
+func TestBadRolls(t *testing.T) {
+	heroes := testParty(t, party.NewMemoryStore(), "heroes")
+	transport := NewFakeTransport()
+	bot := NewBot(transport, testParties{"heroes": heroes}, "heroes")
+	sent := converse(bot, transport, "Colin", "/roll d99999999999999999999", "/roll 1d²",
+		"/roll 999999999d6", "/roll d1000000")
+	assert.Len(t, sent, 4)
+	for _, m := range sent {
+		assert.Contains(t, m.Text, "doesn't start with dice")
+	}
+	assert.Empty(t, heroes.Rolls())
+}
+
+func TestBotRecovers(t *testing.T) {
+	heroes := testParty(t, party.NewMemoryStore(), "heroes")
+	transport := NewFakeTransport()
+	// Rolling in a party that isn't there panics
+	bot := NewBot(transport, testParties{"heroes": heroes, "broken": nil}, "broken")
+	sent := converse(bot, transport, "Colin", "/roll d20", "/party heroes", "/roll d20")
+	assert.Len(t, sent, 3)
+	assert.Equal(t, "Sorry, something went wrong", sent[0].Text)
+	assert.Len(t, heroes.Rolls(), 1)
+}
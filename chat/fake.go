@@ -0,0 +1,46 @@
+package chat
+
+import "sync"
+
+// FakeTransport is a chat that only exists in memory, for tests. Say puts in messages as if
+// someone had said them, and Sent has what's been said back.
+type FakeTransport struct {
+	messages chan Message
+	mutex    sync.Mutex
+	sent     []Message
+}
+
+// NewFakeTransport creates a fake chat that can hold some messages before they're read
+func NewFakeTransport() *FakeTransport {
+	return &FakeTransport{messages: make(chan Message, 100)}
+}
+
+// Messages are the messages said with Say
+func (t *FakeTransport) Messages() <-chan Message {
+	return t.messages
+}
+
+// Send keeps hold of what's said, for Sent
+func (t *FakeTransport) Send(channel, text string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.sent = append(t.sent, Message{channel, "", text})
+	return nil
+}
+
+// Say says something in the chat
+func (t *FakeTransport) Say(channel, author, text string) {
+	t.messages <- Message{channel, author, text}
+}
+
+// Close ends the chat
+func (t *FakeTransport) Close() {
+	close(t.messages)
+}
+
+// Sent is everything that's been sent to the chat
+func (t *FakeTransport) Sent() []Message {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return append([]Message(nil), t.sent...)
+}
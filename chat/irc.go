@@ -0,0 +1,169 @@
+package chat
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// IRC is a transport for a chat on an IRC server. It only needs the standard library, and most
+// chat services can be bridged to IRC.
+type IRC struct {
+	conn     net.Conn
+	nick     string
+	channels []string
+	messages chan Message
+	// mutex is held while writing, as replies and answers to pings are written at the same time
+	mutex sync.Mutex
+}
+
+// DialIRC connects to an IRC server given by a URL like ircs://irc.libera.chat/dnd, joining the
+// channels in the path, separated by commas. ircs uses TLS, and irc doesn't.
+func DialIRC(rawURL, nick string) (*IRC, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("bad IRC URL - %v", err)
+	}
+	var channels []string
+	for _, c := range strings.Split(strings.Trim(u.Path, "/"), ",") {
+		if c != "" && !strings.HasPrefix(c, "#") {
+			c = "#" + c
+		}
+		if c != "" {
+			channels = append(channels, c)
+		}
+	}
+	if len(channels) == 0 {
+		return nil, fmt.Errorf("no channels in IRC URL '%s'", rawURL)
+	}
+	var conn net.Conn
+	switch u.Scheme {
+	case "irc":
+		conn, err = net.Dial("tcp", withPort(u, "6667"))
+	case "ircs":
+		conn, err = tls.Dial("tcp", withPort(u, "6697"), nil)
+	default:
+		return nil, fmt.Errorf("IRC URLs start irc:// or ircs://, not %s://", u.Scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to IRC server - %v", err)
+	}
+	return newIRC(conn, nick, channels), nil
+}
+
+func withPort(u *url.URL, port string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// newIRC starts talking IRC over a connection
+func newIRC(conn net.Conn, nick string, channels []string) *IRC {
+	t := &IRC{conn: conn, nick: nick, channels: channels, messages: make(chan Message)}
+	go t.run()
+	return t
+}
+
+func (t *IRC) write(format string, a ...interface{}) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	_, err := fmt.Fprintf(t.conn, format+"\r\n", a...)
+	return err
+}
+
+// parseIRCLine splits a line from the server into who it's from, the command and its parameters
+func parseIRCLine(line string) (from, command string, params []string) {
+	if strings.HasPrefix(line, ":") {
+		space := strings.Index(line, " ")
+		if space == -1 {
+			return "", "", nil
+		}
+		from, line = line[1:space], line[space+1:]
+		if bang := strings.Index(from, "!"); bang != -1 {
+			from = from[:bang]
+		}
+	}
+	trailing := ""
+	hasTrailing := false
+	if colon := strings.Index(line, " :"); colon != -1 {
+		line, trailing, hasTrailing = line[:colon], line[colon+2:], true
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return from, "", nil
+	}
+	params = fields[1:]
+	if hasTrailing {
+		params = append(params, trailing)
+	}
+	return from, fields[0], params
+}
+
+// run signs in, and reads from the server until the connection is closed
+func (t *IRC) run() {
+	defer close(t.messages)
+	err := t.write("NICK %s", t.nick)
+	if err == nil {
+		err = t.write("USER %s 0 * :D&D dice bot", t.nick)
+	}
+	if err != nil {
+		log.Printf("Error signing in to IRC - %v", err)
+		return
+	}
+	lines := bufio.NewScanner(t.conn)
+	for lines.Scan() {
+		from, command, params := parseIRCLine(lines.Text())
+		switch command {
+		case "PING":
+			err = t.write("PONG :%s", strings.Join(params, " "))
+		case "001":
+			// Signed in, so channels can be joined now
+			err = t.write("JOIN %s", strings.Join(t.channels, ","))
+		case "433":
+			// The nick is taken
+			t.nick += "_"
+			err = t.write("NICK %s", t.nick)
+		case "PRIVMSG":
+			if len(params) != 2 {
+				continue
+			}
+			channel := params[0]
+			if channel == t.nick {
+				// A private message, so answer privately
+				channel = from
+			}
+			t.messages <- Message{channel, from, params[1]}
+		}
+		if err != nil {
+			log.Printf("Error talking to IRC server - %v", err)
+			return
+		}
+	}
+}
+
+// Messages are the messages said in the channels the bot is in, or to it
+func (t *IRC) Messages() <-chan Message {
+	return t.messages
+}
+
+// Send says something in a channel, a line at a time, as that's all IRC allows
+func (t *IRC) Send(channel, text string) error {
+	for _, line := range strings.Split(text, "\n") {
+		err := t.write("PRIVMSG %s :%s", channel, line)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close disconnects from the server
+func (t *IRC) Close() error {
+	return t.conn.Close()
+}
@@ -0,0 +1,65 @@
+package chat
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseIRCLine(t *testing.T) {
+	from, command, params := parseIRCLine(":colin!c@example.com PRIVMSG #dnd :/roll d20 for stealth")
+	assert.Equal(t, "colin", from)
+	assert.Equal(t, "PRIVMSG", command)
+	assert.Equal(t, []string{"#dnd", "/roll d20 for stealth"}, params)
+	from, command, params = parseIRCLine("PING :irc.example.com")
+	assert.Equal(t, "", from)
+	assert.Equal(t, "PING", command)
+	assert.Equal(t, []string{"irc.example.com"}, params)
+}
+
+func TestIRC(t *testing.T) {
+	client, server := net.Pipe()
+	transport := newIRC(client, "dicebot", []string{"#dnd"})
+	lines := bufio.NewScanner(server)
+	expect := func(line string) {
+		assert.True(t, lines.Scan())
+		assert.Equal(t, line, lines.Text())
+	}
+	say := func(line string) {
+		fmt.Fprint(server, line+"\r\n")
+	}
+
+	expect("NICK dicebot")
+	expect("USER dicebot 0 * :D&D dice bot")
+	say(":irc.example.com 433 * dicebot :Nickname is already in use")
+	expect("NICK dicebot_")
+	say(":irc.example.com 001 dicebot_ :Welcome")
+	expect("JOIN #dnd")
+	say("PING :irc.example.com")
+	expect("PONG :irc.example.com")
+
+	say(":colin!c@example.com PRIVMSG #dnd :/roll d20")
+	assert.Equal(t, Message{"#dnd", "colin", "/roll d20"}, <-transport.Messages())
+	// Private messages are answered privately
+	say(":colin!c@example.com PRIVMSG dicebot_ :/party")
+	assert.Equal(t, Message{"colin", "colin", "/party"}, <-transport.Messages())
+
+	go transport.Send("#dnd", "one\ntwo")
+	expect("PRIVMSG #dnd :one")
+	expect("PRIVMSG #dnd :two")
+
+	server.Close()
+	_, open := <-transport.Messages()
+	assert.False(t, open)
+	transport.Close()
+}
+
+func TestDialIRC(t *testing.T) {
+	for _, u := range []string{"http://example.com/dnd", "irc://example.com/", "::"} {
+		_, err := DialIRC(u, "dicebot")
+		assert.Error(t, err, u)
+	}
+}
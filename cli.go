@@ -0,0 +1,291 @@
+package main
+
+import (
+	"dnd/creature"
+	"dnd/dice"
+	"dnd/party"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// A command is something the program does instead of serving, named by its first argument, like
+// "dnd roll 8d6". The party commands work on the party files directly, so the ones that change
+// parties won't run while the server is using the same data directory.
+type command func(args []string, out io.Writer) error
+
+var commands = map[string]command{
+	"roll":      rollCommand,
+	"party":     partyCommand,
+	"encounter": encounterCommand,
+	"recover":   recoverCommand,
+	"tui":       tuiCommand,
+}
+
+// errUsage is returned by commands used wrongly, once they've printed how to use them
+var errUsage = errors.New("bad usage")
+
+// usage prints how to use a command and returns errUsage
+func usage(out io.Writer, lines ...string) error {
+	fmt.Fprintln(out, "Usage:")
+	for _, l := range lines {
+		fmt.Fprintln(out, "  dnd "+l)
+	}
+	return errUsage
+}
+
+// newCommandFlags creates the flags for a command, printing errors to its output
+func newCommandFlags(name string, out io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(out)
+	return flags
+}
+
+func rollCommand(args []string, out io.Writer) error {
+	flags := newCommandFlags("roll", out)
+	stats := flags.Bool("stats", false, "show the least, most and average results too")
+	seed := flags.Int64("seed", 0, "seed for the dice, to get the same results again")
+	err := flags.Parse(args)
+	if err != nil {
+		return errUsage
+	}
+	if flags.NArg() == 0 {
+		return usage(out, "roll [--stats] [--seed n] <dice, e.g. 2d6 + 3>")
+	}
+	roll, err := dice.ParseRollString(strings.Join(flags.Args(), " "))
+	if err != nil {
+		return fmt.Errorf("can't parse roll - %v", err)
+	}
+	result := roll.Simulate()
+	// Zero is a seed like any other, so it matters whether the flag was given, not its value
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "seed" {
+			result = roll.SimulateWith(rand.New(rand.NewSource(*seed)))
+		}
+	})
+	fmt.Fprintf(out, "%s: %s = %d\n", roll, result.StringIndividualRolls(), result.Sum)
+	if *stats {
+		fmt.Fprintf(out, "min %d, max %d, mean %g\n", roll.Min(), roll.Max(), roll.Mean())
+	}
+	return nil
+}
+
+// openStore parses the flags shared by the commands that work on parties, returning the store
+// the config says to use, and the remaining arguments. Commands that change parties lock the data
+// directory. Every command has to call done when it's finished with the store.
+func openStore(flags *flag.FlagSet, args []string, write bool) (store party.Store,
+	done func(), rest []string, err error) {
+	configured, err := loadConfig(nil, os.Getenv)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	dataDir := flags.String("data-dir", configured.DataDir,
+		"directory parties are saved in (default ~/encounters) (env DND_DATA_DIR)")
+	flags.StringVar(&configured.Database, "database", configured.Database,
+		"file to keep all the parties in, instead of the data directory (env DND_DATABASE)")
+	err = flags.Parse(args)
+	if err != nil {
+		return nil, nil, nil, errUsage
+	}
+	directory := getDataDir(*dataDir)
+	unlock := func() {}
+	if write {
+		unlock, err = lockDataDir(directory)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	store, closeStore, err := configured.openStore(directory)
+	if err != nil {
+		unlock()
+		return nil, nil, nil, err
+	}
+	done = func() {
+		closeStore()
+		unlock()
+	}
+	return store, done, flags.Args(), nil
+}
+
+func partyCommand(args []string, out io.Writer) error {
+	store, done, args, err := openStore(newCommandFlags("party", out), args, false)
+	if err != nil {
+		return err
+	}
+	defer done()
+	switch {
+	case len(args) == 1 && args[0] == "list":
+		return listParties(store, out)
+	case len(args) == 2 && args[0] == "show":
+		p, err := store.Load(args[1])
+		if err != nil {
+			return err
+		}
+		showParty(p, out)
+		return nil
+	case len(args) == 2 && args[0] == "export":
+		p, err := store.Load(args[1])
+		if err != nil {
+			return err
+		}
+		return p.Export(out)
+	}
+	return usage(out, "party [--data-dir dir] [--database file] list",
+		"party [--data-dir dir] [--database file] show <party>",
+		"party [--data-dir dir] [--database file] export <party> > party.json")
+}
+
+func listParties(store party.Store, out io.Writer) error {
+	names, err := store.List()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		fmt.Fprintln(out, name)
+	}
+	shelf, ok := store.(party.ShelfStore)
+	if !ok {
+		return nil
+	}
+	archived, err := shelf.Archived()
+	if err != nil {
+		return err
+	}
+	for _, name := range archived {
+		fmt.Fprintln(out, name+" (archived)")
+	}
+	return nil
+}
+
+// showRolls is how many of the latest rolls party show lists
+const showRolls = 5
+
+func showParty(p party.Party, out io.Writer) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintf(w, "Party %s\n", p.Name())
+	fmt.Fprintln(w, "\nPlayers:")
+	for _, pi := range p.PlayerInitiatives() {
+		initiative := "-"
+		if pi.HasInitiative {
+			initiative = strconv.Itoa(pi.Initiative)
+		}
+		fmt.Fprintf(w, "  %s\tinitiative %s\n", pi.Name, initiative)
+	}
+	fmt.Fprintln(w, "\nEncounter:")
+	for _, c := range p.Creatures() {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%d/%d HP\n", c.ID, c.Type.Name, c.Name,
+			c.RolledHealth-c.DamageTaken, c.RolledHealth)
+	}
+	if turnOrder := p.TurnOrder(); len(turnOrder) > 0 {
+		fmt.Fprintln(w, "\nTurn order:")
+		for i, ci := range turnOrder {
+			current := ""
+			if i == p.CurrentTurn() {
+				current = "<- current turn"
+			}
+			fmt.Fprintf(w, "  %d\t%s\t%s\n", ci.Initiative, ci.Name, current)
+		}
+	}
+	fmt.Fprintln(w, "\nLatest rolls:")
+	rolls := p.RecordedRolls()
+	if len(rolls) > showRolls {
+		rolls = rolls[:showRolls]
+	}
+	for _, r := range rolls {
+		fmt.Fprintf(w, "  %s\t%d\t%s\t%s\t%s\n", r.Roll, r.Sum, r.Visibility, r.Who(), r.Label)
+	}
+}
+
+func encounterCommand(args []string, out io.Writer) error {
+	store, done, args, err := openStore(newCommandFlags("encounter", out), args, true)
+	if err != nil {
+		return err
+	}
+	defer done()
+	var action party.Action
+	var ID string
+	switch {
+	case (len(args) == 4 || len(args) == 5) && args[0] == "add":
+		hitDice, err := dice.ParseRollString(args[3])
+		if err != nil {
+			return fmt.Errorf("'%s' isn't a roll - %v", args[3], err)
+		}
+		name := ""
+		if len(args) == 5 {
+			name = args[4]
+		}
+		c := creature.Create(args[2], name, hitDice)
+		action = &party.AddCreatureAction{c}
+		ID = c.ID
+	case len(args) == 4 && args[0] == "damage":
+		amount, err := strconv.Atoi(args[3])
+		if err != nil {
+			return fmt.Errorf("'%s' isn't a number", args[3])
+		}
+		action = &party.DamageCreatureAction{args[2], amount}
+		ID = args[2]
+	default:
+		return usage(out,
+			"encounter [--data-dir dir] [--database file] add <party> <type> <hit dice> [name]",
+			"encounter [--data-dir dir] [--database file] damage <party> <creature ID> <amount>")
+	}
+	p, err := store.Load(args[1])
+	if err != nil {
+		return err
+	}
+	err = p.Apply(action)
+	if err != nil {
+		return err
+	}
+	err = p.Save()
+	if err != nil {
+		return err
+	}
+	c := p.Creature(ID)
+	fmt.Fprintf(out, "%s (%s) has %d/%d HP\n", c.ID, c.Type.Name, c.RolledHealth-c.DamageTaken,
+		c.RolledHealth)
+	return nil
+}
+
+// recoverTimeFormat is how times are given to recover, in local time
+const recoverTimeFormat = "2006-01-02T15:04:05"
+
+// recoverCommand puts a party back how it was at a time, from its journal. The journal is kept,
+// so the party can be recovered again from any time before it was.
+func recoverCommand(args []string, out io.Writer) error {
+	store, done, args, err := openStore(newCommandFlags("recover", out), args, true)
+	if err != nil {
+		return err
+	}
+	defer done()
+	if len(args) != 2 {
+		return usage(out, "recover [--data-dir dir] <party> <time, e.g. 2026-10-01T19:30:00>")
+	}
+	t, err := time.ParseInLocation(recoverTimeFormat, args[1], time.Local)
+	if err != nil {
+		return fmt.Errorf("'%s' isn't a time like 2026-10-01T19:30:00", args[1])
+	}
+	journalled, ok := store.(party.JournalStore)
+	if !ok {
+		return errors.New("parties in a database don't have journals to recover them from")
+	}
+	p, err := journalled.LoadAt(args[0], t)
+	if err != nil {
+		return err
+	}
+	err = p.Save()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Recovered %s as it was at %s\n\n", p.Name(), t.Format(recoverTimeFormat))
+	showParty(p, out)
+	return nil
+}
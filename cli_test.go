@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"dnd/party"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func runCommand(args ...string) (string, error) {
+	var out bytes.Buffer
+	err := commands[args[0]](args[1:], &out)
+	return out.String(), err
+}
+
+func TestRollCommand(t *testing.T) {
+	first, err := runCommand("roll", "--seed", "42", "8d6")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(first, "8d6: "))
+	again, _ := runCommand("roll", "--seed", "42", "8d6")
+	assert.Equal(t, first, again)
+	zero, _ := runCommand("roll", "--seed", "0", "8d6")
+	again, _ = runCommand("roll", "--seed", "0", "8d6")
+	assert.Equal(t, zero, again)
+
+	out, err := runCommand("roll", "--stats", "2d6", "+", "3")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "min 5, max 15, mean 10")
+	_, err = runCommand("roll", "2x6")
+	assert.Error(t, err)
+	_, err = runCommand("roll")
+	assert.Equal(t, errUsage, err)
+}
+
+func TestPartyCommands(t *testing.T) {
+	d, err := ioutil.TempDir("", "cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	assert.NoError(t, party.NewFileStore(d).Save(party.New("", "heroes")))
+
+	out, err := runCommand("party", "--data-dir", d, "list")
+	assert.NoError(t, err)
+	assert.Equal(t, "heroes\n", out)
+
+	out, err = runCommand("encounter", "--data-dir", d, "add", "heroes", "orc", "15", "Grom")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "has 15/15 HP")
+	ID := strings.Fields(out)[0]
+	out, err = runCommand("encounter", "--data-dir", d, "damage", "heroes", ID, "4")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "has 11/15 HP")
+	_, err = runCommand("encounter", "--data-dir", d, "damage", "heroes", "0bad1d", "4")
+	assert.Error(t, err)
+
+	out, err = runCommand("party", "--data-dir", d, "show", "heroes")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Grom")
+	assert.Contains(t, out, "11/15 HP")
+
+	out, err = runCommand("party", "--data-dir", d, "export", "heroes")
+	assert.NoError(t, err)
+	var exported map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(out), &exported))
+	assert.Equal(t, "heroes", exported["name"])
+
+	_, err = runCommand("party", "--data-dir", d, "show", "villains")
+	assert.Error(t, err)
+	_, err = runCommand("party", "--data-dir", d, "rename", "heroes")
+	assert.Equal(t, errUsage, err)
+
+	// While the server has the data directory, parties can be looked at but not changed
+	unlock, err := lockDataDir(d)
+	assert.NoError(t, err)
+	_, err = runCommand("encounter", "--data-dir", d, "damage", "heroes", ID, "4")
+	assert.Equal(t, errDataDirLocked, err)
+	_, err = runCommand("party", "--data-dir", d, "show", "heroes")
+	assert.NoError(t, err)
+	unlock()
+	_, err = runCommand("encounter", "--data-dir", d, "damage", "heroes", ID, "4")
+	assert.NoError(t, err)
+
+	// The database is used instead of the data directory if there is one
+	database := filepath.Join(d, "parties.db")
+	store, err := party.OpenDatabaseStore(database)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Save(party.New("", "villains")))
+	assert.NoError(t, store.Close())
+	out, err = runCommand("party", "--data-dir", d, "--database", database, "list")
+	assert.NoError(t, err)
+	assert.Equal(t, "villains\n", out)
+	out, err = runCommand("encounter", "--data-dir", d, "--database", database, "add", "villains",
+		"orc", "15")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "has 15/15 HP")
+	store, err = party.OpenDatabaseStore(database)
+	assert.NoError(t, err)
+	villains, err := store.Load("villains")
+	assert.NoError(t, err)
+	assert.Len(t, villains.Creatures(), 1)
+	assert.NoError(t, store.Close())
+}
+
+func TestRecoverCommand(t *testing.T) {
+	d, err := ioutil.TempDir("", "cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	assert.NoError(t, party.NewFileStore(d).Save(party.New("", "heroes")))
+	out, err := runCommand("encounter", "--data-dir", d, "add", "heroes", "orc", "15", "Grom")
+	assert.NoError(t, err)
+	ID := strings.Fields(out)[0]
+	// Times are given to the second, so wait for the next one before changing anything else
+	before := time.Now().Truncate(time.Second).Add(time.Second)
+	time.Sleep(time.Until(before) + 10*time.Millisecond)
+	_, err = runCommand("encounter", "--data-dir", d, "damage", "heroes", ID, "4")
+	assert.NoError(t, err)
+
+	out, err = runCommand("recover", "--data-dir", d, "heroes", before.Format(recoverTimeFormat))
+	assert.NoError(t, err)
+	assert.Contains(t, out, "15/15 HP")
+	out, err = runCommand("party", "--data-dir", d, "show", "heroes")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "15/15 HP")
+	// The old journal is kept
+	files, _ := filepath.Glob(filepath.Join(d, "heroes.party.journal.*"))
+	assert.Len(t, files, 1)
+
+	_, err = runCommand("recover", "--data-dir", d, "heroes", "2000-01-01T00:00:00")
+	assert.Error(t, err)
+	_, err = runCommand("recover", "--data-dir", d, "heroes", "yesterday")
+	assert.Error(t, err)
+	_, err = runCommand("recover", "--data-dir", d, "villains", before.Format(recoverTimeFormat))
+	assert.Equal(t, party.ErrNoSuchParty, err)
+	_, err = runCommand("recover", "--data-dir", d, "heroes")
+	assert.Equal(t, errUsage, err)
+	_, err = runCommand("recover", "--data-dir", d, "--database", filepath.Join(d, "parties.db"),
+		"heroes", before.Format(recoverTimeFormat))
+	assert.Error(t, err)
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"dnd/party"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// config is how the server is set up. Each setting comes from, in order of preference, a
+// command line flag, an environment variable, the config file, and then the default.
+type config struct {
+	// Listen is the address to serve on, e.g. localhost:1212 or :443
+	Listen string `json:"listen"`
+	// DataDir is where parties are saved. If it's empty, it's encounters in the home directory.
+	DataDir string `json:"dataDir"`
+	// Database is a file to keep all the parties in, instead of a file each in DataDir. Parties
+	// can't be archived or backed up there.
+	Database string `json:"database"`
+	// Dev is whether to use the templates and static files on disk rather than the copies built
+	// into the binary, so that changes to them show up without rebuilding
+	Dev bool `json:"dev"`
+	// TemplateDir and StaticDir are where the page templates and the stylesheets etc. are in dev
+	// mode
+	TemplateDir string `json:"templateDir"`
+	StaticDir   string `json:"staticDir"`
+	// TLSCert and TLSKey are the certificate and key files to serve HTTPS with. If neither is set
+	// it's plain HTTP.
+	TLSCert string `json:"tlsCert"`
+	TLSKey  string `json:"tlsKey"`
+	// DMPassword is the DM's password, or DMPasswordFile a file it's in. If neither is set, the
+	// password saved in DataDir is used.
+	DMPassword     string `json:"dmPassword"`
+	DMPasswordFile string `json:"dmPasswordFile"`
+	// BackupSessions and BackupDays are how many session and daily backups of each party to keep.
+	// Zero turns that kind of backup off.
+	BackupSessions int `json:"backupSessions"`
+	BackupDays     int `json:"backupDays"`
+	// ChatIRC is the IRC server and channels for the chat bot to roll dice in, e.g.
+	// ircs://irc.libera.chat/dnd. If it's empty there's no bot. ChatNick is the bot's nick, and
+	// ChatParty the party it starts out rolling for.
+	ChatIRC   string `json:"chatIRC"`
+	ChatNick  string `json:"chatNick"`
+	ChatParty string `json:"chatParty"`
+}
+
+func defaultConfig() config {
+	return config{"localhost:1212", "", "", false, "templates", "static", "", "", "", "",
+		party.DefaultBackupPolicy.Sessions, party.DefaultBackupPolicy.Days, "", "dicebot", ""}
+}
+
+// configSetting ties a setting to the flag and environment variable that set it
+type configSetting struct {
+	flag, env, usage string
+	value            func(*config) *string
+}
+
+var configSettings = []configSetting{
+	{"listen", "DND_LISTEN", "address to serve on",
+		func(c *config) *string { return &c.Listen }},
+	{"data-dir", "DND_DATA_DIR", "directory parties are saved in (default ~/encounters)",
+		func(c *config) *string { return &c.DataDir }},
+	{"database", "DND_DATABASE", "file to keep all the parties in, instead of the data directory",
+		func(c *config) *string { return &c.Database }},
+	{"template-dir", "DND_TEMPLATE_DIR", "directory of the page templates",
+		func(c *config) *string { return &c.TemplateDir }},
+	{"static-dir", "DND_STATIC_DIR", "directory of the static files",
+		func(c *config) *string { return &c.StaticDir }},
+	{"tls-cert", "DND_TLS_CERT", "certificate file, to serve HTTPS",
+		func(c *config) *string { return &c.TLSCert }},
+	{"tls-key", "DND_TLS_KEY", "private key file for the certificate",
+		func(c *config) *string { return &c.TLSKey }},
+	{"dm-password", "DND_DM_PASSWORD", "the DM's password (others can see it, so prefer the file)",
+		func(c *config) *string { return &c.DMPassword }},
+	{"dm-password-file", "DND_DM_PASSWORD_FILE", "file containing the DM's password",
+		func(c *config) *string { return &c.DMPasswordFile }},
+	{"chat-irc", "DND_CHAT_IRC", "IRC server and channels for the chat bot, e.g. ircs://host/channel",
+		func(c *config) *string { return &c.ChatIRC }},
+	{"chat-nick", "DND_CHAT_NICK", "the chat bot's nick",
+		func(c *config) *string { return &c.ChatNick }},
+	{"chat-party", "DND_CHAT_PARTY", "party the chat bot rolls for",
+		func(c *config) *string { return &c.ChatParty }},
+}
+
+// configCount is a setting that's a number, like configSetting
+type configCount struct {
+	flag, env, usage string
+	value            func(*config) *int
+}
+
+var configCounts = []configCount{
+	{"backup-sessions", "DND_BACKUP_SESSIONS", "how many session backups of each party to keep",
+		func(c *config) *int { return &c.BackupSessions }},
+	{"backup-days", "DND_BACKUP_DAYS", "how many daily backups of each party to keep",
+		func(c *config) *int { return &c.BackupDays }},
+}
+
+// readConfigFile overrides the settings in a config with those in a JSON file. Settings the
+// file leaves out keep their values.
+func readConfigFile(c *config, filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("can't read config file - %v", err)
+	}
+	err = json.Unmarshal(data, c)
+	if err != nil {
+		return fmt.Errorf("can't parse config file '%s' - %v", filename, err)
+	}
+	return nil
+}
+
+// loadConfig works out the config from the command line arguments (without the program name)
+// and the environment. The config file is named by the -config flag or DND_CONFIG.
+func loadConfig(args []string, getenv func(string) string) (config, error) {
+	c := defaultConfig()
+	flags := flag.NewFlagSet("dnd", flag.ContinueOnError)
+	configFile := flags.String("config", getenv("DND_CONFIG"), "JSON config file (env DND_CONFIG)")
+	// Flags are parsed into a separate config, so that it's known which were actually given
+	var fromFlags config
+	for _, s := range configSettings {
+		usage := s.usage
+		if d := *s.value(&c); d != "" {
+			usage += fmt.Sprintf(" (default %q)", d)
+		}
+		flags.StringVar(s.value(&fromFlags), s.flag, "", usage+" (env "+s.env+")")
+	}
+	for _, s := range configCounts {
+		flags.IntVar(s.value(&fromFlags), s.flag, *s.value(&c), s.usage+" (env "+s.env+")")
+	}
+	flags.BoolVar(&fromFlags.Dev, "dev", false,
+		"use the templates and static files on disk rather than the built in ones (env DND_DEV)")
+	err := flags.Parse(args)
+	if err != nil {
+		return c, err
+	}
+	if flags.NArg() > 0 {
+		return c, fmt.Errorf("unexpected arguments %v", flags.Args())
+	}
+
+	if *configFile != "" {
+		err = readConfigFile(&c, *configFile)
+		if err != nil {
+			return c, err
+		}
+	}
+	for _, s := range configSettings {
+		if v := getenv(s.env); v != "" {
+			*s.value(&c) = v
+		}
+	}
+	for _, s := range configCounts {
+		if v := getenv(s.env); v != "" {
+			*s.value(&c), err = strconv.Atoi(v)
+			if err != nil {
+				return c, fmt.Errorf("%s should be a number, not '%s'", s.env, v)
+			}
+		}
+	}
+	if v := getenv("DND_DEV"); v != "" {
+		c.Dev, err = strconv.ParseBool(v)
+		if err != nil {
+			return c, fmt.Errorf("DND_DEV should be true or false, not '%s'", v)
+		}
+	}
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "dev" {
+			c.Dev = fromFlags.Dev
+		}
+		for _, s := range configSettings {
+			if s.flag == f.Name {
+				*s.value(&c) = *s.value(&fromFlags)
+			}
+		}
+		for _, s := range configCounts {
+			if s.flag == f.Name {
+				*s.value(&c) = *s.value(&fromFlags)
+			}
+		}
+	})
+
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return c, errors.New("HTTPS needs both a certificate and a key")
+	}
+	if c.DMPassword != "" && c.DMPasswordFile != "" {
+		return c, errors.New("the DM password can't be given both directly and in a file")
+	}
+	if c.BackupSessions < 0 || c.BackupDays < 0 {
+		return c, errors.New("can't keep fewer than no backups")
+	}
+	if c.ChatIRC != "" && c.ChatParty == "" {
+		return c, errors.New("the chat bot needs a party to roll for")
+	}
+	if c.Listen == "" {
+		return c, errors.New("no address to listen on")
+	}
+	return c, nil
+}
+
+// dmPassword is the DM's password from the config, reading it from its file if it's in one. It's
+// empty if it isn't set.
+func (c config) dmPassword() (string, error) {
+	if c.DMPasswordFile == "" {
+		return c.DMPassword, nil
+	}
+	data, err := ioutil.ReadFile(c.DMPasswordFile)
+	if err != nil {
+		return "", fmt.Errorf("can't read DM password file - %v", err)
+	}
+	password := strings.TrimSpace(string(data))
+	if password == "" {
+		return "", fmt.Errorf("DM password file '%s' is empty", c.DMPasswordFile)
+	}
+	return password, nil
+}
+
+// openStore opens where the config says parties are kept: the database if there is one, and the
+// data directory if not. done has to be called when the store isn't needed any more.
+func (c config) openStore(dataDir string) (store party.Store, done func(), err error) {
+	policy := party.BackupPolicy{c.BackupSessions, c.BackupDays}
+	if c.Database != "" {
+		database, err := party.OpenDatabaseStore(c.Database)
+		if err != nil {
+			return nil, nil, err
+		}
+		database.BackupPolicy = policy
+		return database, func() { database.Close() }, nil
+	}
+	fileStore := party.NewFileStore(dataDir)
+	fileStore.BackupPolicy = policy
+	return fileStore, func() {}, nil
+}
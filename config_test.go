@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfig(t *testing.T) {
+	noEnv := func(string) string { return "" }
+	c, err := loadConfig(nil, noEnv)
+	assert.NoError(t, err)
+	assert.Equal(t, defaultConfig(), c)
+
+	d, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	file := filepath.Join(d, "dnd.json")
+	ioutil.WriteFile(file, []byte(`{"listen": ":8080", "dataDir": "/srv/dnd", "staticDir": "/srv/static"}`), 0640)
+	env := map[string]string{"DND_CONFIG": file, "DND_DATA_DIR": "/var/dnd"}
+	c, err = loadConfig([]string{"-listen", ":9090"}, func(k string) string { return env[k] })
+	assert.NoError(t, err)
+	// Flags beat the environment, which beats the file, which beats the defaults
+	assert.Equal(t, ":9090", c.Listen)
+	assert.Equal(t, "/var/dnd", c.DataDir)
+	assert.Equal(t, "/srv/static", c.StaticDir)
+	assert.Equal(t, "templates", c.TemplateDir)
+	assert.False(t, c.Dev)
+
+	env["DND_DEV"] = "true"
+	c, err = loadConfig(nil, func(k string) string { return env[k] })
+	assert.NoError(t, err)
+	assert.True(t, c.Dev)
+	c, err = loadConfig([]string{"-dev=false"}, func(k string) string { return env[k] })
+	assert.NoError(t, err)
+	assert.False(t, c.Dev)
+	env["DND_DEV"] = "sometimes"
+	_, err = loadConfig(nil, func(k string) string { return env[k] })
+	assert.Error(t, err)
+
+	env["DND_DEV"] = ""
+	ioutil.WriteFile(file, []byte(`{"backupSessions": 3, "backupDays": 5}`), 0640)
+	env["DND_BACKUP_DAYS"] = "7"
+	c, err = loadConfig([]string{"-backup-sessions", "0"}, func(k string) string { return env[k] })
+	assert.NoError(t, err)
+	assert.Equal(t, 0, c.BackupSessions)
+	assert.Equal(t, 7, c.BackupDays)
+	env["DND_BACKUP_DAYS"] = "lots"
+	_, err = loadConfig(nil, func(k string) string { return env[k] })
+	assert.Error(t, err)
+	_, err = loadConfig([]string{"-backup-days", "-1"}, noEnv)
+	assert.Error(t, err)
+
+	passwordFile := filepath.Join(d, "password")
+	ioutil.WriteFile(passwordFile, []byte("hunter2\n"), 0600)
+	c, err = loadConfig([]string{"-dm-password-file", passwordFile}, noEnv)
+	assert.NoError(t, err)
+	password, err := c.dmPassword()
+	assert.NoError(t, err)
+	assert.Equal(t, "hunter2", password)
+	env = map[string]string{"DND_DM_PASSWORD": "swordfish"}
+	c, err = loadConfig(nil, func(k string) string { return env[k] })
+	assert.NoError(t, err)
+	password, err = c.dmPassword()
+	assert.NoError(t, err)
+	assert.Equal(t, "swordfish", password)
+	_, err = loadConfig([]string{"-dm-password", "a", "-dm-password-file", passwordFile}, noEnv)
+	assert.Error(t, err)
+
+	env = map[string]string{"DND_CHAT_IRC": "irc://localhost/dnd"}
+	_, err = loadConfig(nil, func(k string) string { return env[k] })
+	assert.Error(t, err)
+	c, err = loadConfig([]string{"-chat-party", "heroes"}, func(k string) string { return env[k] })
+	assert.NoError(t, err)
+	assert.Equal(t, "irc://localhost/dnd", c.ChatIRC)
+	assert.Equal(t, "dicebot", c.ChatNick)
+
+	_, err = loadConfig([]string{"-tls-cert", "cert.pem"}, noEnv)
+	assert.Error(t, err)
+	_, err = loadConfig([]string{"-config", filepath.Join(d, "missing.json")}, noEnv)
+	assert.Error(t, err)
+	_, err = loadConfig([]string{"-listen", ":1", "extra"}, noEnv)
+	assert.Error(t, err)
+}
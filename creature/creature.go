@@ -1,6 +1,12 @@
 package creature
 
-import "dnd/dice"
+import (
+	"crypto/rand"
+	"dnd/dice"
+	"encoding/hex"
+	"log"
+	"regexp"
+)
 
 // Type is a type of creature
 type Type struct {
@@ -8,16 +14,38 @@ type Type struct {
 	HitDice *dice.Roll
 }
 
-// Creature is an individual creature
+// Creature is an individual creature. ID identifies it for as long as it's around, wherever it
+// is in the encounter.
 type Creature struct {
+	ID                        string
 	Type                      *Type
 	Name                      string
 	RolledHealth, DamageTaken int
 }
 
+// NewID makes up an ID for a creature
+func NewID() string {
+	b := make([]byte, 6)
+	_, err := rand.Read(b)
+	if err != nil {
+		// The system's random number generator is broken, and nothing's going to work anyway
+		log.Panicf("Error generating creature ID - %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+var idRegexp = regexp.MustCompile(`^[0-9a-f]+$`)
+
+// ValidID is whether a string could be a creature's ID. IDs are lower case hex, as they're used
+// in URLs.
+func ValidID(ID string) bool {
+	return idRegexp.MatchString(ID)
+}
+
 // Create a creature of a given type and name with given hit dice
 func Create(creatureType string, name string, hitDice *dice.Roll) *Creature {
 	return &Creature{
+		NewID(),
 		&Type{creatureType, hitDice},
 		name,
 		hitDice.Simulate().Sum,
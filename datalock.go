@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// dataDirLock is the file in the data directory that the server, and the commands that change
+// parties, lock while they use it, so that they don't overwrite each other's changes
+const dataDirLock = "dnd.lock"
+
+var errDataDirLocked = errors.New("the data directory is in use by the server or another command")
+
+// lockDataDir locks the data directory, failing with errDataDirLocked if something else has, and
+// returns how to unlock it. The lock goes when the program stops, however it stops.
+func lockDataDir(dataDir string) (unlock func(), err error) {
+	f, err := os.OpenFile(filepath.Join(dataDir, dataDirLock), os.O_RDWR|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("error opening lock file - %v", err)
+	}
+	err = lockFile(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	// Closing the file releases the lock
+	return func() { f.Close() }, nil
+}
@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package main
+
+import "os"
+
+// lockFile does nothing where there's no flock, so the data directory isn't protected there
+func lockFile(f *os.File) error {
+	return nil
+}
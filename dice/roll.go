@@ -35,21 +35,27 @@ func tokenisediceUnitString(diceRollString string) ([]diceUnitToken, error) {
 	state := notReadingAnything
 	var builder strings.Builder
 
-	tokeniseNumberIfNecessary := func() {
+	tokeniseNumberIfNecessary := func() error {
 		if state == readingNumber {
 			state = notReadingAnything
-			number, error := strconv.Atoi(builder.String())
-			if error != nil {
-				panic(error)
+			number, err := strconv.Atoi(builder.String())
+			if err != nil {
+				return fmt.Errorf("%s is too big a number for dice", builder.String())
 			}
 			tokenised = append(tokenised, numberDiceUnitToken(number))
 			builder.Reset()
 		}
+		return nil
 	}
 
 	for _, r := range diceRollString {
-		if !unicode.IsNumber(r) {
-			tokeniseNumberIfNecessary()
+		// Only ASCII digits, as strconv can't read other kinds of number, like ²
+		isDigit := r >= '0' && r <= '9'
+		if !isDigit {
+			err := tokeniseNumberIfNecessary()
+			if err != nil {
+				return nil, err
+			}
 		}
 		switch r {
 		case 'd', 'D':
@@ -57,7 +63,7 @@ func tokenisediceUnitString(diceRollString string) ([]diceUnitToken, error) {
 		case '+', '-':
 			tokenised = append(tokenised, signDiceUnitToken(r))
 		default:
-			if unicode.IsNumber(r) {
+			if isDigit {
 				state = readingNumber
 				builder.WriteRune(r)
 			} else if !unicode.IsSpace(r) {
@@ -65,7 +71,10 @@ func tokenisediceUnitString(diceRollString string) ([]diceUnitToken, error) {
 			}
 		}
 	}
-	tokeniseNumberIfNecessary()
+	err := tokeniseNumberIfNecessary()
+	if err != nil {
+		return nil, err
+	}
 	return tokenised, nil
 }
 
@@ -132,6 +141,11 @@ type FaceCountMapResult struct {
 }
 
 func (faceCount *FaceCountMap) SimulateResult() *FaceCountMapResult {
+	return faceCount.simulateResult(rand.Intn)
+}
+
+// simulateResult rolls the dice with a random number source, given as its Intn
+func (faceCount *FaceCountMap) simulateResult(intn func(int) int) *FaceCountMapResult {
 	faceCount.sortFacesDescending()
 	var result FaceCountMapResult
 	result.rolls = make([][]uint, len(faceCount.Faces))
@@ -139,7 +153,7 @@ func (faceCount *FaceCountMap) SimulateResult() *FaceCountMapResult {
 		count := faceCount.Counts[face]
 		result.rolls[i] = make([]uint, count)
 		for j := uint(0); j < count; j++ {
-			roll := 1 + uint(rand.Intn(int(face)))
+			roll := 1 + uint(intn(int(face)))
 			result.sum += roll
 			result.rolls[i][j] = roll
 		}
@@ -204,6 +218,48 @@ func (roll Roll) Max() int {
 	return int(roll.Positive.Max()) - int(roll.Negative.Min()) + roll.Offset
 }
 
+// Mean is the average result of the roll. Every die is as likely to come up high as low, so it's
+// halfway between the least and the most.
+func (roll Roll) Mean() float64 {
+	return float64(roll.Min()+roll.Max()) / 2
+}
+
+// Expression writes the roll in a form that ParseRollString can read back. Unlike String, it
+// never brackets the negative dice, so "d6 - (d4 + d8)" comes out as "d6 - d4 - d8", and it
+// starts anything negative with a 0, as the parser can't handle a leading minus.
+func (roll *Roll) Expression() string {
+	var b strings.Builder
+	b.WriteString(roll.Positive.String())
+	if b.Len() == 0 {
+		b.WriteRune('0')
+	}
+	for _, face := range roll.Negative.Faces {
+		b.WriteString(" - ")
+		count := roll.Negative.Counts[face]
+		if count > 1 {
+			writeUint(&b, count)
+		}
+		b.WriteRune('d')
+		writeUint(&b, face)
+	}
+	if roll.Offset > 0 {
+		b.WriteString(" + ")
+		b.WriteString(strconv.Itoa(roll.Offset))
+	} else if roll.Offset < 0 {
+		b.WriteString(" - ")
+		b.WriteString(strconv.Itoa(-roll.Offset))
+	}
+	return b.String()
+}
+
+// MaxDice and MaxSides are the most dice a roll can have, and the most sides a die can have.
+// Every die is rolled separately, so there has to be a limit for rolls from anyone on the
+// internet.
+const (
+	MaxDice  = 1000
+	MaxSides = 1000
+)
+
 // ParseRollString can read strings of the forms...
 // d6
 // -d6
@@ -272,6 +328,15 @@ func ParseRollString(diceRollString string) (*Roll, error) {
 			default:
 				return nil, errors.New(fmt.Sprint("Invalid die ", die))
 			}
+			if count != 0 && faces == 0 {
+				return nil, errors.New("dice need at least one side")
+			}
+			if faces > MaxSides {
+				return nil, fmt.Errorf("dice can't have more than %d sides", MaxSides)
+			}
+			if count > MaxDice || rolls.Positive.Min()+rolls.Negative.Min()+int(count) > MaxDice {
+				return nil, fmt.Errorf("can't roll more than %d dice at once", MaxDice)
+			}
 			if count != 0 {
 				if nextIsNegative {
 					rolls.Negative.add(count, faces)
@@ -300,10 +365,20 @@ type RollResult struct {
 }
 
 func (roll *Roll) Simulate() RollResult {
+	return roll.simulate(rand.Intn)
+}
+
+// SimulateWith rolls the dice with a source of random numbers of its own, e.g. one made with a
+// seed, so that the same results come up again
+func (roll *Roll) SimulateWith(r *rand.Rand) RollResult {
+	return roll.simulate(r.Intn)
+}
+
+func (roll *Roll) simulate(intn func(int) int) RollResult {
 	var result RollResult
 	result.Roll = roll
-	positiveResults := roll.Positive.SimulateResult()
-	negativeResults := roll.Negative.SimulateResult()
+	positiveResults := roll.Positive.simulateResult(intn)
+	negativeResults := roll.Negative.simulateResult(intn)
 	result.PositiveResults = positiveResults.rolls
 	result.NegativeResults = negativeResults.rolls
 	result.Sum = int(positiveResults.sum) - int(negativeResults.sum) + roll.Offset
@@ -1,6 +1,7 @@
 package dice
 
 import (
+	"math/rand"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -33,3 +34,59 @@ func TestStringFaceCountMapResults(t *testing.T) {
 	assert.Equal(t, "(1 + 2) + 3", StringFaceCountMapResults([][]uint{[]uint{1, 2}, []uint{3}}))
 	assert.Equal(t, "1 + (2 + 3)", StringFaceCountMapResults([][]uint{[]uint{1}, []uint{2, 3}}))
 }
+
+func TestExpressionParsesBack(t *testing.T) {
+	for _, s := range []string{"d20", "0 - d20", "2d6 - 2", "3d8 + 2d6 + 2", "d6 - d4 - 2d8 + 1", "7", "0 - 3"} {
+		roll, err := ParseRollString(s)
+		assert.NoError(t, err)
+		reparsed, err := ParseRollString(roll.Expression())
+		assert.NoError(t, err)
+		assert.Equal(t, roll.String(), reparsed.String())
+	}
+	roll, _ := ParseRollString("d6 - d4 - d8")
+	assert.Equal(t, "d6 - (d4 + d8)", roll.String())
+	assert.Equal(t, "d6 - d4 - d8", roll.Expression())
+	roll, _ = ParseRollString("0 - d4")
+	assert.Equal(t, "-d4", roll.String())
+	assert.Equal(t, "0 - d4", roll.Expression())
+}
+
+func TestRollStats(t *testing.T) {
+	roll, _ := ParseRollString("2d6 - d4 + 1")
+	assert.Equal(t, -1, roll.Min())
+	assert.Equal(t, 12, roll.Max())
+	assert.Equal(t, 5.5, roll.Mean())
+}
+
+func TestZeroSidedDice(t *testing.T) {
+	_, err := ParseRollString("d0")
+	assert.Error(t, err)
+	_, err = ParseRollString("2d0 + 1")
+	assert.Error(t, err)
+}
+
+func TestTooManyDice(t *testing.T) {
+	tooMany := []string{"999999999d6", "1001d6", "600d6 - 600d4", "d1001", "18446744073709551615d6"}
+	for _, s := range tooMany {
+		_, err := ParseRollString(s)
+		assert.Error(t, err, s)
+	}
+	_, err := ParseRollString("1000d1000")
+	assert.NoError(t, err)
+}
+
+func TestBadNumbers(t *testing.T) {
+	bad := []string{"d99999999999999999999", "99999999999999999999", "1d²", "²d6", "d٣"}
+	for _, s := range bad {
+		_, err := ParseRollString(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestSimulateWith(t *testing.T) {
+	roll, _ := ParseRollString("8d6 - d4")
+	first := roll.SimulateWith(rand.New(rand.NewSource(7)))
+	again := roll.SimulateWith(rand.New(rand.NewSource(7)))
+	assert.Equal(t, first, again)
+	assert.True(t, first.Sum >= roll.Min() && first.Sum <= roll.Max())
+}
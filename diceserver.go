@@ -6,43 +6,83 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
 type DiceServer struct {
-	Template *template.Template
-	Party    party.Party
+	templates *templateSet
 }
 
 type RollTemplateValues struct {
 	HasResult      bool
-	Rolls          []*dice.RollResult
+	Rolls          []*party.RecordedRoll
 	LastCustomRoll string
+	JoinCode       string
+	Players        []*rollingPlayer
+}
+
+// rollingPlayer is a player who might be rolling from their phone
+type rollingPlayer struct {
+	Name   string
+	Locked bool
 }
 
 func (diceServer *DiceServer) GetTemplate() *template.Template {
-	return diceServer.Template
+	return diceServer.templates.page("roll.html")
 }
 
-func (diceServer *DiceServer) GenerateTemplateData(r *http.Request) interface{} {
+func (diceServer *DiceServer) GenerateTemplateData(r *http.Request, p party.Party) interface{} {
 	var templateValues RollTemplateValues
-	templateValues.LastCustomRoll = diceServer.Party.CustomRoll()
-	templateValues.Rolls = diceServer.Party.Rolls()
+	templateValues.LastCustomRoll = p.CustomRoll()
+	templateValues.Rolls = p.RecordedRolls()
+	templateValues.JoinCode = p.JoinCode()
+	for _, pi := range p.PlayerInitiatives() {
+		templateValues.Players = append(templateValues.Players,
+			&rollingPlayer{pi.Name, p.PlayerLocked(pi.Name)})
+	}
 	return templateValues
 }
 
-func (diceServer *DiceServer) HandlePost(r *http.Request) error {
-	roll, err := dice.ParseRollString(r.Form["roll"][0])
-	if err != nil {
-		// TODO: Handle invalid roll strings better
-		return fmt.Errorf("error parsing roll string")
+// HandlePost rolls the dice. It also handles:
+// /roll/reveal/(roll ID), revealing a hidden roll
+// /roll/lock, locking or unlocking the player in the form
+// /roll/join-code, making a new join code
+// Rolls aren't undoable, so the action is nil unless it's a reveal or lock.
+func (diceServer *DiceServer) HandlePost(r *http.Request, p party.Party) (party.ReversibleAction, error) {
+	switch r.URL.Path {
+	case "/roll/lock":
+		return p.LockPlayerAction(r.Form.Get("player"), r.Form.Get("locked") == "true")
+	case "/roll/join-code":
+		p.NewJoinCode()
+		return nil, nil
 	}
-	if len(r.Form["roll-custom"]) > 0 {
-		diceServer.Party.SetCustomRoll(r.Form["roll"][0])
+	if strings.HasPrefix(r.URL.Path, "/roll/reveal/") {
+		ID, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/roll/reveal/"))
+		if err != nil {
+			return nil, fmt.Errorf("can't reveal roll '%s' - %v", r.URL.Path, err)
+		}
+		return p.RevealRollAction(ID)
 	}
-	diceServer.Party.AddRoll(roll.Simulate())
-	err = diceServer.Party.Save()
+	visibility := party.PublicRoll
+	if v := r.Form.Get("visibility"); v != "" {
+		var err error
+		visibility, err = party.ParseRollVisibility(v)
+		if err != nil {
+			return nil, invalid("", "%v", err)
+		}
+	}
+	rollString := strings.TrimSpace(r.Form.Get("roll"))
+	if rollString == "" {
+		return nil, invalid("roll", "Type some dice to roll, like 2d6 + 3")
+	}
+	roll, err := dice.ParseRollString(rollString)
 	if err != nil {
-		return fmt.Errorf("error saving party - %v", err)
+		return nil, invalid("roll", "'%s' isn't a roll - %v", rollString, err)
+	}
+	if len(r.Form["roll-custom"]) > 0 {
+		p.SetCustomRoll(rollString)
 	}
-	return nil
+	p.AddRoll(roll.Simulate(), visibility)
+	return nil, nil
 }
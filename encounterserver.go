@@ -4,7 +4,6 @@ import (
 	"dnd/creature"
 	"dnd/dice"
 	"dnd/party"
-	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
@@ -25,44 +24,47 @@ type EncounterData struct {
 }
 
 type EncounterServer struct {
-	template      *template.Template
+	templates     *templateSet
 	postURLRegexp *regexp.Regexp
 }
 
 // NewEncounterServer creates
-func NewEncounterServer(t *template.Template) (*EncounterServer, error) {
-	r, err := regexp.Compile(`^/encounter/((?:new-creature)|(?:damage)|(?:delete))(?:/(\d+))?$`)
+func NewEncounterServer(templates *templateSet) (*EncounterServer, error) {
+	r, err := regexp.Compile(`^/encounter/((?:new-creature)|(?:damage)|(?:delete))(?:/([0-9a-f]+))?$`)
 	if err != nil {
 		return nil, fmt.Errorf("can't compile URL regex - %v", err)
 	}
-	return &EncounterServer{t, r}, nil
+	return &EncounterServer{templates, r}, nil
+}
+
+// healthClass is the CSS class for how hurt a creature is
+func healthClass(c *creature.Creature) string {
+	if c.DamageTaken >= c.RolledHealth {
+		return "dead"
+	}
+	if 2*c.DamageTaken >= c.RolledHealth {
+		return "damaged"
+	}
+	return ""
 }
 
 func (s *EncounterServer) GetTemplate() *template.Template {
-	return s.template
+	return s.templates.page("encounter.html")
 }
 
 func (s *EncounterServer) GenerateTemplateData(r *http.Request, p party.Party) interface{} {
 	creatureCount := len(p.Creatures())
 	creatureInformations := make([]CreatureInformation, creatureCount)
 	for i, creature := range p.Creatures() {
-		var hc string
-		if 2*creature.DamageTaken >= creature.RolledHealth {
-			hc = "damaged"
-		}
-		if creature.DamageTaken >= creature.RolledHealth {
-			hc = "dead"
-		}
 		creatureInformationIndex := creatureCount - 1 - i
-		strI := strconv.Itoa(i)
 		creatureInformations[creatureInformationIndex] = CreatureInformation{
 			creature.Type.Name,
 			creature.Name,
-			"damageAmount" + strI,
-			"/encounter/delete/" + strI,
+			"damageAmount" + creature.ID,
+			"/encounter/delete/" + creature.ID,
 			creature.RolledHealth - creature.DamageTaken,
 			creature.RolledHealth,
-			hc}
+			healthClass(creature)}
 	}
 	data := EncounterData{creatureInformations, "", ""}
 	if creatureCount > 0 {
@@ -77,7 +79,7 @@ func (s *EncounterServer) GenerateTemplateData(r *http.Request, p party.Party) i
 // the form of the url path is one of
 // /encounter/new-creature
 // /encounter/damage
-// /encounter/delete/(creatureID)
+// /encounter/delete/(creature ID)
 func (s *EncounterServer) HandlePost(r *http.Request, p party.Party) (party.ReversibleAction, error) {
 	args := s.postURLRegexp.FindStringSubmatch(r.URL.Path)
 	if args == nil {
@@ -85,29 +87,35 @@ func (s *EncounterServer) HandlePost(r *http.Request, p party.Party) (party.Reve
 	}
 	action := args[1]
 	if action == "new-creature" {
-		roll, err := dice.ParseRollString(r.Form["creatureHitDice"][0])
+		creatureType := strings.TrimSpace(r.Form.Get("creatureType"))
+		if creatureType == "" {
+			return nil, invalid("creatureType", "Every creature needs a type")
+		}
+		hitDice := strings.TrimSpace(r.Form.Get("creatureHitDice"))
+		if hitDice == "" {
+			return nil, invalid("creatureHitDice", "Every creature needs hit dice, like 2d8 + 2")
+		}
+		roll, err := dice.ParseRollString(hitDice)
 		if err != nil {
-			return nil, fmt.Errorf("error parsing creature dice string - %v", err)
+			return nil, invalid("creatureHitDice", "'%s' isn't a roll - %v", hitDice, err)
 		}
 		return &party.AddCreatureAction{creature.Create(
-			r.Form["creatureType"][0],
-			r.Form["creatureName"][0],
+			creatureType,
+			strings.TrimSpace(r.Form.Get("creatureName")),
 			roll)}, nil
 	} // else
 	if action == "damage" {
 		actions := make([]party.DamageCreatureAction, 0)
 		for k, v := range r.Form {
 			if strings.HasPrefix(k, "damageAmount") {
-				creatureID, err := strconv.Atoi(k[len("damageAmount"):])
-				if err != nil {
-					return nil, fmt.Errorf("critical error deriving id: %v", err)
-				}
-				if v[0] == "Amount" {
+				creatureID := k[len("damageAmount"):]
+				amount := strings.TrimSpace(v[0])
+				if amount == "Amount" || amount == "" {
 					continue
 				}
-				damageAmount, err := strconv.Atoi(v[0])
+				damageAmount, err := strconv.Atoi(amount)
 				if err != nil {
-					return nil, fmt.Errorf("couldn't parse damage amount: %v", err)
+					return nil, invalid(k, "'%s' isn't a number", v[0])
 				}
 				if damageAmount != 0 {
 					actions = append(actions, party.DamageCreatureAction{
@@ -116,7 +124,7 @@ func (s *EncounterServer) HandlePost(r *http.Request, p party.Party) (party.Reve
 			}
 		}
 		if len(actions) == 0 {
-			return nil, errors.New("no creatures damaged in action")
+			return nil, invalid("", "Type how much damage to do first")
 		} else if len(actions) == 1 {
 			return &actions[0], nil
 		} else {
@@ -126,12 +134,8 @@ func (s *EncounterServer) HandlePost(r *http.Request, p party.Party) (party.Reve
 	if len(args) != 3 {
 		return nil, fmt.Errorf("unexpected number of args from regex (%d) - %#v", len(args), args)
 	} // else
-	creatureID, err := strconv.Atoi(args[2])
-	if err != nil {
-		return nil, fmt.Errorf("error parsing int from %v - %v", args[2], err)
-	}
 	if action != "delete" {
 		return nil, fmt.Errorf("unrecognised action - %v", action)
 	}
-	return p.DeleteCreatureAction(creatureID), nil
+	return p.DeleteCreatureAction(args[2]), nil
 }
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"dnd/creature"
+	"dnd/dice"
+	"dnd/party"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func postToEncounter(s *EncounterServer, p party.Party, path string, form url.Values) (party.ReversibleAction, error) {
+	r := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.ParseForm()
+	return s.HandlePost(r, p)
+}
+
+func TestEncounterValidation(t *testing.T) {
+	s, err := NewEncounterServer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := party.New("", "heroes")
+	roll, _ := dice.ParseRollString("10")
+	p.Apply(&party.AddCreatureAction{creature.Create("orc", "grom", roll)})
+
+	// Missing fields are errors about the field, rather than panics
+	_, err = postToEncounter(s, p, "/encounter/new-creature", url.Values{})
+	assert.Equal(t, "creatureType", err.(*ValidationError).Field)
+	_, err = postToEncounter(s, p, "/encounter/new-creature", url.Values{"creatureType": {"orc"}})
+	assert.Equal(t, "creatureHitDice", err.(*ValidationError).Field)
+	ID := p.Creatures()[0].ID
+	_, err = postToEncounter(s, p, "/encounter/damage", url.Values{"damageAmount" + ID: {"lots"}})
+	assert.Equal(t, "damageAmount"+ID, err.(*ValidationError).Field)
+
+	// A page from before a creature was deleted can't damage or delete it
+	action, err := postToEncounter(s, p, "/encounter/damage", url.Values{"damageAmount0bad1d": {"4"}})
+	assert.NoError(t, err)
+	assert.Error(t, p.Apply(action))
+	action, err = postToEncounter(s, p, "/encounter/delete/0bad1d", url.Values{})
+	assert.NoError(t, err)
+	assert.Error(t, p.Apply(action))
+	assert.Len(t, p.Creatures(), 1)
+	assert.Equal(t, 0, p.Creatures()[0].DamageTaken)
+}
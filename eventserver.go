@@ -0,0 +1,48 @@
+package main
+
+import (
+	"dnd/party"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// eventKeepAlive is how often a comment is sent down an idle event stream, so that proxies and
+// browsers don't give up on it
+const eventKeepAlive = 30 * time.Second
+
+// serveEvents streams a server-sent event every time the party changes, so that every page open
+// on the party can update itself
+func serveEvents(w http.ResponseWriter, r *http.Request, p party.Party) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	changes, unsubscribe := p.Subscribe()
+	defer unsubscribe()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case change := <-changes:
+			data, err := json.Marshal(change)
+			if err != nil {
+				log.Printf("Error encoding change - %v", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: change\nid: %d\ndata: %s\n\n", change.Seq, data)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bufio"
+	"dnd/party"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventStream(t *testing.T) {
+	store := party.NewMemoryStore()
+	is, err := newInitialisationServer(store, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, is.createParty("heroes"))
+	servers, err := newPartyServers(store, builtInTemplates(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(newPartyRouter(is, servers))
+	defer server.Close()
+
+	events, err := http.Get(server.URL + "/p/heroes/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer events.Body.Close()
+	assert.Equal(t, "text/event-stream", events.Header.Get("Content-Type"))
+
+	// Posting while the stream is open mustn't wait for it to close
+	response, err := http.PostForm(server.URL+"/p/heroes/roll/",
+		map[string][]string{"roll": {"d20"}, "redirectURI": {"/p/heroes/"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	stream := bufio.NewReader(events.Body)
+	lines := make([]string, 0)
+	for len(lines) < 3 {
+		line, err := stream.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	assert.Equal(t, []string{"event: change", "id: 1", `data: {"seq":1,"kind":"roll"}`}, lines)
+}
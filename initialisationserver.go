@@ -2,39 +2,49 @@ package main
 
 import (
 	"dnd/party"
+	"errors"
 	"fmt"
 	"html/template"
-	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/user"
 	"path/filepath"
-	"strconv"
-	"strings"
+	"sync"
 )
 
 type PartyInitialisationData struct {
-	Name, URL string
+	Name, URL, ExportURL string
 }
 
+type initialisationTemplateData struct {
+	Parties, Archived []PartyInitialisationData
+}
+
+// initialisationServer is the party chooser. It also keeps hold of every party that has been
+// loaded, so that there's only ever one copy of each, and the lock for changing each one.
 type initialisationServer struct {
-	dataDir                string
-	template               *template.Template
-	parties                []party.Party
-	InitialisationComplete bool
-	Party                  party.Party
+	store     party.Store
+	templates *templateSet
+	mutex     sync.Mutex
+	parties   map[string]party.Party
+	locks     map[party.Party]*sync.RWMutex
 }
 
-func getDataDir() string {
-	usr, err := user.Current()
-	if err != nil {
-		log.Fatal(err)
+// getDataDir is the directory parties are saved in, creating it if need be. It's the configured
+// one, or encounters in the home directory if there isn't one.
+func getDataDir(dataDir string) string {
+	if dataDir == "" {
+		usr, err := user.Current()
+		if err != nil {
+			log.Fatal(err)
+		}
+		dataDir = filepath.Join(usr.HomeDir, "encounters")
 	}
-	dataDir := filepath.Join(usr.HomeDir, "encounters")
 	if _, err := os.Stat(dataDir); os.IsNotExist(err) {
 		log.Printf("encounters directory '%s' does not exist, creating...", dataDir)
-		err = os.Mkdir(dataDir, 0750)
+		err = os.MkdirAll(dataDir, 0750)
 		if err != nil {
 			log.Printf("Oh no, I don't believe it! Error creating directory - %v", err)
 		}
@@ -42,77 +52,258 @@ func getDataDir() string {
 	return dataDir
 }
 
-func newInitialisationServer(dataDir string, t *template.Template) (*initialisationServer, error) {
-	s := initialisationServer{dataDir, t, make([]party.Party, 0), false, nil}
-	contents, err := ioutil.ReadDir(s.dataDir)
-	if err != nil {
-		return nil, fmt.Errorf("error when reading data directory - %v", err)
-	}
-	i := 0
-	for _, fileInfo := range contents {
-		if !fileInfo.IsDir() && strings.HasSuffix(fileInfo.Name(), ".party.gob") {
-			fileName := filepath.Join(s.dataDir, fileInfo.Name())
-			file, err := os.Open(fileName)
-			if err != nil {
-				log.Printf("Error opening .party.gob file - %v", err)
-			}
-			defer file.Close()
-			p, err := party.Load(file)
-			if err != nil {
-				log.Printf("Error loading party '%s' - %v", fileName, err)
-				continue
-			}
-			s.parties = append(s.parties, p)
-			i++
-		}
+func newInitialisationServer(store party.Store, templates *templateSet) (*initialisationServer, error) {
+	_, err := store.List()
+	if err != nil {
+		return nil, fmt.Errorf("error listing parties - %v", err)
+	}
+	return &initialisationServer{store: store, templates: templates,
+		parties: make(map[string]party.Party), locks: make(map[party.Party]*sync.RWMutex)}, nil
+}
+
+// Party gets a party by name, loading it from the store the first time it's asked for
+func (s *initialisationServer) Party(name string) (party.Party, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if p, ok := s.parties[name]; ok {
+		return p, nil
+	}
+	p, err := s.store.Load(name)
+	if err != nil {
+		return nil, err
 	}
-	return &s, nil
+	s.parties[name] = p
+	return p, nil
 }
 
 func (s *initialisationServer) GetTemplate() *template.Template {
-	return s.template
+	return s.templates.page("choosegroup.html")
+}
+
+func partyInitialisationData(names []string) []PartyInitialisationData {
+	data := make([]PartyInitialisationData, len(names))
+	for i, name := range names {
+		escaped := url.PathEscape(name)
+		data[i] = PartyInitialisationData{name, "/p/" + escaped + "/", "/export/" + escaped}
+	}
+	return data
 }
 
 func (s *initialisationServer) GenerateTemplateData(r *http.Request) interface{} {
-	partyInitialisationData := make([]PartyInitialisationData, len(s.parties))
-	for i, p := range s.parties {
-		partyInitialisationData[i] = PartyInitialisationData{
-			p.Name(),
-			fmt.Sprintf("/%d", i)}
+	data := &initialisationTemplateData{}
+	names, err := s.store.List()
+	if err != nil {
+		log.Printf("Error listing parties - %v", err)
+	}
+	data.Parties = partyInitialisationData(names)
+	if store, ok := s.store.(party.ShelfStore); ok {
+		archived, err := store.Archived()
+		if err != nil {
+			log.Printf("Error listing archived parties - %v", err)
+		}
+		data.Archived = partyInitialisationData(archived)
+	}
+	return data
+}
+
+// addParty saves a party that isn't in the store yet
+func (s *initialisationServer) addParty(p party.Party) error {
+	if !party.ValidName(p.Name()) {
+		return fmt.Errorf("'%s' can't be used as a party name", p.Name())
+	}
+	_, err := s.Party(p.Name())
+	if err != party.ErrNoSuchParty {
+		return fmt.Errorf("there's already a party called '%s'", p.Name())
 	}
-	return partyInitialisationData
+	err = s.store.Save(p)
+	if err != nil {
+		return fmt.Errorf("error saving party - %v", err)
+	}
+	s.mutex.Lock()
+	s.parties[p.Name()] = p
+	s.mutex.Unlock()
+	return nil
+}
+
+// isLoaded is whether a party is still the one loaded under its name
+func (s *initialisationServer) isLoaded(p party.Party) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.parties[p.Name()] == p
+}
+
+// lock is the lock for changing a party. Anything that changes a party holds it for writing, and
+// then checks the party is still loaded before saving it, as it might have been renamed or put
+// away while it waited.
+func (s *initialisationServer) lock(p party.Party) *sync.RWMutex {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	l, ok := s.locks[p]
+	if !ok {
+		l = &sync.RWMutex{}
+		s.locks[p] = l
+	}
+	return l
+}
+
+// forget drops a party that has been moved or removed in the store, so it's loaded afresh
+func (s *initialisationServer) forget(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.locks, s.parties[name])
+	delete(s.parties, name)
 }
 
-func (s *initialisationServer) initialiseWithNewParty(name string) error {
-	s.Party = party.New(s.dataDir, name)
-	err := s.Party.Save()
+// moveParty moves a party in the store, and forgets it. Nothing can be changing the party while
+// it's moved, or the change would be saved under the old name, bringing the party back.
+func (s *initialisationServer) moveParty(name string, move func() error) error {
+	s.mutex.Lock()
+	p, loaded := s.parties[name]
+	s.mutex.Unlock()
+	if loaded {
+		l := s.lock(p)
+		l.Lock()
+		defer l.Unlock()
+	}
+	err := move()
+	s.forget(name)
+	return err
+}
+
+func (s *initialisationServer) createParty(name string) error {
+	err := s.addParty(party.New("", name))
 	if err != nil {
-		log.Printf("Error encoding party - %v", err)
+		return err
 	}
 	log.Printf("Created new party '%s'", name)
 	return nil
 }
 
-func (s *initialisationServer) HandlePost(r *http.Request) error {
-	argument, err := getURLArgument(r.URL)
+func (s *initialisationServer) renameParty(oldName, newName string) error {
+	err := s.moveParty(oldName, func() error { return s.store.Rename(oldName, newName) })
 	if err != nil {
-		return fmt.Errorf("error getting argument - %v", err)
+		return err
 	}
-	if argument == "" {
-		err := s.initialiseWithNewParty(r.Form["partyName"][0])
-		if err != nil {
-			return fmt.Errorf("error creating file for party - %v", err)
-		}
-	} else {
-		i, err := strconv.Atoi(argument)
-		if err != nil {
-			return fmt.Errorf("error parsing party index - %v", err)
-		}
-		if i < 0 || i >= len(s.parties) {
-			return fmt.Errorf("party index %d out of range", i)
-		}
-		s.Party = s.parties[i]
+	log.Printf("Renamed party '%s' to '%s'", oldName, newName)
+	return nil
+}
+
+// duplicateParty starts a new party with the players from an existing one
+func (s *initialisationServer) duplicateParty(name, newName string) error {
+	p, err := s.Party(name)
+	if err != nil {
+		return err
 	}
-	s.InitialisationComplete = true
+	err = s.addParty(p.Duplicate(newName))
+	if err != nil {
+		return err
+	}
+	log.Printf("Duplicated party '%s' as '%s'", name, newName)
+	return nil
+}
+
+func (s *initialisationServer) shelfStore() (party.ShelfStore, error) {
+	store, ok := s.store.(party.ShelfStore)
+	if !ok {
+		return nil, errors.New("parties can't be put away in this store")
+	}
+	return store, nil
+}
+
+// trashParty moves a party into the trash
+func (s *initialisationServer) trashParty(name string) error {
+	store, err := s.shelfStore()
+	if err != nil {
+		return err
+	}
+	err = s.moveParty(name, func() error { return store.Trash(name) })
+	if err != nil {
+		return err
+	}
+	log.Printf("Moved party '%s' to the trash", name)
 	return nil
 }
+
+func (s *initialisationServer) archiveParty(name string) error {
+	store, err := s.shelfStore()
+	if err != nil {
+		return err
+	}
+	err = s.moveParty(name, func() error { return store.Archive(name) })
+	if err != nil {
+		return err
+	}
+	log.Printf("Archived party '%s'", name)
+	return nil
+}
+
+func (s *initialisationServer) unarchiveParty(name string) error {
+	store, err := s.shelfStore()
+	if err != nil {
+		return err
+	}
+	err = store.Unarchive(name)
+	if err != nil {
+		return err
+	}
+	log.Printf("Unarchived party '%s'", name)
+	return nil
+}
+
+// importParty reads a party exported as JSON into the store. It won't replace an existing party
+// with the same name.
+func (s *initialisationServer) importParty(r *http.Request) error {
+	file, _, err := r.FormFile("partyFile")
+	if err != nil {
+		return fmt.Errorf("error reading uploaded party - %v", err)
+	}
+	defer file.Close()
+	p, err := party.Import(file, "")
+	if err != nil {
+		return err
+	}
+	err = s.addParty(p)
+	if err != nil {
+		return err
+	}
+	log.Printf("Imported party '%s'", p.Name())
+	return nil
+}
+
+// ServeExport sends the party whose name is at the end of the URL as JSON
+func (s *initialisationServer) ServeExport(w http.ResponseWriter, r *http.Request) {
+	name, err := getURLArgument(r.URL)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	p, err := s.Party(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	serveExport(w, p)
+}
+
+// HandlePost creates a new party, or imports one if the URL is /import. The rest of the URLs
+// work on the party named in the party field.
+func (s *initialisationServer) HandlePost(r *http.Request) error {
+	name := r.Form.Get("party")
+	switch r.URL.Path {
+	case "/":
+		return s.createParty(r.Form.Get("partyName"))
+	case "/import":
+		return s.importParty(r)
+	case "/rename":
+		return s.renameParty(name, r.Form.Get("newName"))
+	case "/duplicate":
+		return s.duplicateParty(name, r.Form.Get("newName"))
+	case "/trash":
+		return s.trashParty(name)
+	case "/archive":
+		return s.archiveParty(name)
+	case "/unarchive":
+		return s.unarchiveParty(name)
+	}
+	return fmt.Errorf("unrecognised endpoint: '%v'", r.URL.Path)
+}
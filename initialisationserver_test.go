@@ -1,9 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"dnd/party"
 	"io/ioutil"
+	"mime/multipart"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"strings"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestPartyLoadSaving(t *testing.T) {
@@ -11,18 +19,22 @@ func TestPartyLoadSaving(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Couldn't create temporary directory - %v", err)
 	}
-	is, err := newInitialisationServer(d, nil)
+	is, err := newInitialisationServer(party.NewFileStore(d), nil)
 	if err != nil {
 		t.Error(err)
 	}
-	is.initialiseWithNewParty("foo")
-	is, err = newInitialisationServer(d, nil)
+	assert.NoError(t, is.createParty("foo"))
+	assert.Error(t, is.createParty("foo"))
+	is, err = newInitialisationServer(party.NewFileStore(d), nil)
 	if err != nil {
 		t.Error(err)
 	}
-	if len(is.parties) != 1 || is.parties[0].Name() != "foo" {
+	p, err := is.Party("foo")
+	if err != nil || p.Name() != "foo" {
 		t.Error("Error with stored party")
 	}
+	again, _ := is.Party("foo")
+	assert.True(t, p == again)
 	defer func() {
 		err := os.RemoveAll(d)
 		if err != nil {
@@ -30,3 +42,86 @@ func TestPartyLoadSaving(t *testing.T) {
 		}
 	}()
 }
+
+func TestImportParty(t *testing.T) {
+	store := party.NewMemoryStore()
+	is, err := newInitialisationServer(store, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exported bytes.Buffer
+	party.New("", "imported").Export(&exported)
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	f, _ := w.CreateFormFile("partyFile", "imported.party.json")
+	f.Write(exported.Bytes())
+	w.WriteField("redirectURI", "/")
+	w.Close()
+	r := httptest.NewRequest("POST", "/import", &body)
+	r.Header.Set("Content-Type", w.FormDataContentType())
+	_, err = ParseFormAndGetRedirectURI(r)
+	assert.NoError(t, err)
+	assert.NoError(t, is.HandlePost(r))
+
+	is, err = newInitialisationServer(store, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, _ := store.List()
+	assert.Equal(t, []string{"imported"}, names)
+	p, err := is.Party("imported")
+	assert.NoError(t, err)
+	assert.Equal(t, "imported", p.Name())
+
+	// Importing the same party again would clobber it
+	r = httptest.NewRequest("POST", "/import", bytes.NewReader(body.Bytes()))
+	r.Header.Set("Content-Type", w.FormDataContentType())
+	ParseFormAndGetRedirectURI(r)
+	assert.Error(t, is.HandlePost(r))
+}
+
+func postToChooser(t *testing.T, is *initialisationServer, path string, form url.Values) error {
+	form.Set("redirectURI", "/")
+	r := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	_, err := ParseFormAndGetRedirectURI(r)
+	assert.NoError(t, err)
+	return is.HandlePost(r)
+}
+
+func TestManagingParties(t *testing.T) {
+	store := party.NewMemoryStore()
+	is, err := newInitialisationServer(store, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, is.createParty("heroes"))
+	heroes, _ := is.Party("heroes")
+	heroes.Apply(&party.AddPlayerAction{"Anya"})
+
+	assert.NoError(t, postToChooser(t, is, "/duplicate", url.Values{"party": {"heroes"}, "newName": {"sequel"}}))
+	sequel, err := is.Party("sequel")
+	assert.NoError(t, err)
+	assert.Equal(t, "Anya", sequel.PlayerInitiatives()[0].Name)
+
+	assert.NoError(t, postToChooser(t, is, "/rename", url.Values{"party": {"heroes"}, "newName": {"legends"}}))
+	assert.Error(t, postToChooser(t, is, "/rename", url.Values{"party": {"legends"}, "newName": {"sequel"}}))
+	_, err = is.Party("heroes")
+	assert.Equal(t, party.ErrNoSuchParty, err)
+	legends, err := is.Party("legends")
+	assert.NoError(t, err)
+	assert.Equal(t, "legends", legends.Name())
+
+	assert.NoError(t, postToChooser(t, is, "/archive", url.Values{"party": {"legends"}}))
+	assert.NoError(t, postToChooser(t, is, "/trash", url.Values{"party": {"sequel"}}))
+	data := is.GenerateTemplateData(nil).(*initialisationTemplateData)
+	assert.Empty(t, data.Parties)
+	assert.Equal(t, "legends", data.Archived[0].Name)
+
+	assert.NoError(t, postToChooser(t, is, "/unarchive", url.Values{"party": {"legends"}}))
+	_, err = is.Party("legends")
+	assert.NoError(t, err)
+	_, err = is.Party("sequel")
+	assert.Equal(t, party.ErrNoSuchParty, err)
+}
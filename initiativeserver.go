@@ -1,35 +1,56 @@
 package main
 
 import (
+	"dnd/dice"
 	"dnd/party"
-	"errors"
+	"fmt"
 	"html/template"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
 type InitiativeServer struct {
-	template *template.Template
+	templates *templateSet
 }
 
 // GetTemplate gets the template
 func (s *InitiativeServer) GetTemplate() *template.Template {
-	return s.template
+	return s.templates.page("initiative.html")
 }
 
 type creatureInitiativeInformation struct {
 	Name, InputName, Value string
 }
 
+type turnInformation struct {
+	Name       string
+	Initiative int
+	Current    bool
+}
+
 type initiativeTemplateData struct {
 	PlayerInformation []*creatureInitiativeInformation
+	TurnOrder         []*turnInformation
+}
+
+// turnOrderInformation is the turn order with whoever's turn it is marked
+func turnOrderInformation(p party.Party) []*turnInformation {
+	order := p.TurnOrder()
+	current := p.CurrentTurn()
+	turns := make([]*turnInformation, len(order))
+	for i, c := range order {
+		turns[i] = &turnInformation{c.Name, c.Initiative, i == current}
+	}
+	return turns
 }
 
 // GenerateTemplateData returns the data for the template
 func (s *InitiativeServer) GenerateTemplateData(r *http.Request, p party.Party) interface{} {
 	pis := p.PlayerInitiatives()
 	data := &initiativeTemplateData{
-		make([]*creatureInitiativeInformation, len(pis))}
+		make([]*creatureInitiativeInformation, len(pis)),
+		turnOrderInformation(p)}
 	for i, pi := range pis {
 		initiativeString := ""
 		if pi.HasInitiative {
@@ -43,9 +64,73 @@ func (s *InitiativeServer) GenerateTemplateData(r *http.Request, p party.Party)
 	return data
 }
 
-// HandlePost needs to work out whether to roll the initiatives or add a new
-// creature or party member
-func (s *InitiativeServer) HandlePost(r *http.Request, p party.Party) (party.ReversibleAction, error) {
-	return nil, errors.New("unimplemented :(")
+// The new player and creature inputs start off with these in them
+const (
+	newPlayerPlaceholder   = "New Player"
+	newCreaturePlaceholder = "New Creature"
+)
+
+// formName gets a name typed into the form, ignoring the placeholder
+func formName(r *http.Request, field, placeholder string) string {
+	name := strings.TrimSpace(r.Form.Get(field))
+	if name == placeholder {
+		return ""
+	}
+	return name
+}
+
+// parseInitiative reads an initiative that was typed in. Blank means no initiative.
+func parseInitiative(value string) (*party.CreatureInitiative, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return &party.CreatureInitiative{"", false, 0}, nil
+	}
+	initiative, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, fmt.Errorf("initiative '%s' isn't a number", value)
+	}
+	return &party.CreatureInitiative{"", true, initiative}, nil
+}
 
+// HandlePost either moves on to the next turn, for /initiative/next-turn, or sets the initiatives
+// of the players and adds a new player or creature
+func (s *InitiativeServer) HandlePost(r *http.Request, p party.Party) (party.ReversibleAction, error) {
+	if r.URL.Path == "/initiative/next-turn" {
+		return &party.NextTurnAction{}, nil
+	}
+	pis := p.PlayerInitiatives()
+	change := party.InitiativeChange{Players: make([]*party.CreatureInitiative, len(pis))}
+	for i, pi := range pis {
+		// Without this, a form from before a player joined would clear everyone's initiatives
+		if _, ok := r.Form[strconv.Itoa(i)]; !ok {
+			return nil, invalid("", "The initiatives have changed since the page was loaded - try again")
+		}
+		initiative, err := parseInitiative(r.Form.Get(strconv.Itoa(i)))
+		if err != nil {
+			return nil, invalid(strconv.Itoa(i), "%s's %v", pi.Name, err)
+		}
+		initiative.Name = pi.Name
+		change.Players[i] = initiative
+	}
+	if name := formName(r, "newPlayerName", newPlayerPlaceholder); name != "" {
+		initiative, err := parseInitiative(r.Form.Get("newPlayerInitiative"))
+		if err != nil {
+			return nil, invalid("newPlayerInitiative", "%s's %v", name, err)
+		}
+		initiative.Name = name
+		change.NewPlayer = initiative
+	}
+	if name := formName(r, "newCreatureName", newCreaturePlaceholder); name != "" {
+		// Creatures' initiatives can be dice, like d20 + 2, which get rolled
+		initiative := strings.TrimSpace(r.Form.Get("newCreatureInitiative"))
+		if initiative == "" {
+			initiative = "d20"
+		}
+		roll, err := dice.ParseRollString(initiative)
+		if err != nil {
+			return nil, invalid("newCreatureInitiative", "'%s' isn't a roll - %v", initiative, err)
+		}
+		change.NewCreature = &party.EncounterCreature{name, *roll, roll.Simulate().Sum}
+	}
+	return p.InitiativeAction(change)
 }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"dnd/party"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+)
+
+// LogServer shows the session log, lets the DM add notes to it, and exports it as markdown
+type LogServer struct {
+	templates *templateSet
+}
+
+type logTemplateData struct {
+	PartyName string
+	Sessions  []*party.LogSession
+}
+
+// GetTemplate gets the template
+func (s *LogServer) GetTemplate() *template.Template {
+	return s.templates.page("log.html")
+}
+
+// GenerateTemplateData groups the party's log for display
+func (s *LogServer) GenerateTemplateData(r *http.Request, p party.Party) interface{} {
+	return &logTemplateData{p.Name(), party.GroupLog(p.SessionLog())}
+}
+
+// The note input starts off with this in it
+const notePlaceholder = "Add a note"
+
+// HandlePost adds a note to the log. Notes aren't undoable, so the action is always nil.
+func (s *LogServer) HandlePost(r *http.Request, p party.Party) (party.ReversibleAction, error) {
+	if r.URL.Path != "/log/note" {
+		return nil, fmt.Errorf("unrecognised endpoint: '%v'", r.URL.Path)
+	}
+	note := formName(r, "note", notePlaceholder)
+	if note == "" {
+		return nil, invalid("note", "Type a note to add to the log")
+	}
+	p.AddNote(note)
+	return nil, nil
+}
+
+// ServeMarkdown writes the party's log as a markdown file download
+func (s *LogServer) ServeMarkdown(w http.ResponseWriter, p party.Party) {
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=\"%s-log.md\"", p.Name()))
+	err := party.WriteLogMarkdown(w, p.Name()+" session log", p.SessionLog())
+	if err != nil {
+		log.Printf("Error writing markdown log - %v", err)
+	}
+}
@@ -4,55 +4,25 @@ import (
 	"dnd/party"
 	"fmt"
 	"html/template"
-	"log"
 	"net/http"
 )
 
 type OverviewServer struct {
-	template         *template.Template
+	templates        *templateSet
 	encounterServer  *EncounterServer
 	diceServer       *DiceServer
 	initiativeServer *InitiativeServer
 }
 
-// This is a bit complicated. I've implemented this slightly crazy template inheritance system.
-// In order to do this overview pages, I want to render a bunch of different BodyContent templates
-// from other templates. To do this, I have to prefix them with a source, and then preserve the
-// original root of the template.
-func attachPrefixedTemplate(root, child *template.Template, prefix string) (*template.Template, error) {
-	rootName := root.Name()
-	t, err := root.AddParseTree(prefix+child.Name(), child.Tree)
-	if err != nil {
-		return nil, err
-	}
-	return t.Lookup(rootName), nil
-}
-
-// NewOverviewServer creeates a new overview server, attaching the templates.
-func NewOverviewServer(t *template.Template, es *EncounterServer,
+// NewOverviewServer creates a new overview server. Its template has the other servers' content
+// attached when it's loaded.
+func NewOverviewServer(templates *templateSet, es *EncounterServer,
 	ds *DiceServer, is *InitiativeServer) *OverviewServer {
-
-	t, err := attachPrefixedTemplate(t, es.GetTemplate().Lookup("BodyContent"), "Encounter")
-	if err != nil {
-		log.Fatalf("Catastrophic error attaching encounter body content - %v", err)
-	}
-	t, err = attachPrefixedTemplate(t, ds.GetTemplate().Lookup("BodyContent"), "Roll")
-	if err != nil {
-		log.Fatalf("Catastrophic error attaching roll body content - %v", err)
-	}
-	t, err = attachPrefixedTemplate(t, ds.GetTemplate().Lookup("HeadContent"), "Roll")
-	if err != nil {
-		log.Fatalf("Catastrophic error attaching roll head content - %v", err)
-	}
-	t, err = attachPrefixedTemplate(t, is.GetTemplate().Lookup("BodyContent"), "Initiative")
-	if err != nil {
-		log.Fatalf("Catastrophic error attaching initiative head content: %v", err)
-	}
-	return &OverviewServer{t, es, ds, is}
+	return &OverviewServer{templates, es, ds, is}
 }
 
 func (os *OverviewServer) GetTemplate() *template.Template {
-	return os.template
+	return os.templates.page("overview.html")
 }
 
 type OverviewTemplateData struct {
@@ -74,7 +44,7 @@ func (os *OverviewServer) GenerateTemplateData(r *http.Request, p party.Party) i
 	data := OverviewTemplateData{
 		os.initiativeServer.GenerateTemplateData(r, p),
 		os.encounterServer.GenerateTemplateData(r, p),
-		os.diceServer.GenerateTemplateData(r),
+		os.diceServer.GenerateTemplateData(r, p),
 		undoDisabled,
 		redoDisabled,
 	}
@@ -1,12 +1,56 @@
 package party
 
-import "dnd/creature"
+import (
+	"dnd/creature"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Actions are stored in the party's undo buffer, which is saved along with it, so gob needs to
+// know every concrete type.
+func init() {
+	gob.Register(&AddCreatureAction{})
+	// The names these would be registered under belong to the legacy actions from before
+	// creatures had IDs
+	gob.RegisterName("*party.DamageCreatureAction.v2", &DamageCreatureAction{})
+	gob.RegisterName("dnd/party.DamageMultipleCreaturesAction.v2", DamageMultipleCreaturesAction{})
+	gob.RegisterName("*party.DeleteCreatureAction.v2", &DeleteCreatureAction{})
+	gob.Register(&AddPlayerAction{})
+	gob.Register(&RestoreAction{})
+}
 
 // Action is a modification of the party
 type Action interface {
 	apply(*party)
 }
 
+// validator is an action that can check it makes sense for the party before it's applied, like
+// one from a stale page for a creature that has since been deleted
+type validator interface {
+	validate(*party) error
+}
+
+// creatureIndex is where the creature with an ID is in the encounter, or -1 if it isn't there
+func (p *party) creatureIndex(ID string) int {
+	for i, c := range p.EncounterCreatures {
+		if c.ID == ID {
+			return i
+		}
+	}
+	return -1
+}
+
+// checkCreature checks there's a creature with an ID
+func (p *party) checkCreature(ID string) error {
+	if p.creatureIndex(ID) == -1 {
+		return fmt.Errorf("there's no creature '%s' - it may have been removed", ID)
+	}
+	return nil
+}
+
 // ReversibleAction is an action that can be undone.
 // undo must take the Party after applying its change, and return it as it was before apply.
 type ReversibleAction interface {
@@ -19,30 +63,81 @@ type AddCreatureAction struct {
 	Creature *creature.Creature
 }
 
+func (a *AddCreatureAction) validate(p *party) error {
+	if a.Creature == nil || a.Creature.Type == nil || a.Creature.Type.HitDice == nil {
+		return errors.New("can't add a creature without a type and hit dice")
+	}
+	return nil
+}
+
 func (a *AddCreatureAction) apply(p *party) {
+	// The creature may have been saved in the undo history before creatures had IDs
+	ensureCreatureIDs([]*creature.Creature{a.Creature})
 	p.EncounterCreatures = append(p.EncounterCreatures, a.Creature)
 }
 
 func (a *AddCreatureAction) undo(p *party) {
+	// Keep hold of the creature actually in the encounter: after a save and load this action
+	// holds a copy, which would be out of date by the time it is redone.
+	a.Creature = p.EncounterCreatures[len(p.EncounterCreatures)-1]
 	p.EncounterCreatures = p.EncounterCreatures[:len(p.EncounterCreatures)-1]
 }
 
-// DamageCreatureAction subtracts a number of hitpoints from a creature
+func (a *AddCreatureAction) describe(p *party) string {
+	return fmt.Sprintf("%s joined the encounter with %d HP", describeCreature(a.Creature),
+		a.Creature.RolledHealth)
+}
+
+// describeCreature names a creature for the session log, falling back to its type if it was
+// never given a name
+func describeCreature(c *creature.Creature) string {
+	if c.Name == "" {
+		return c.Type.Name
+	}
+	return fmt.Sprintf("%s (%s)", c.Name, c.Type.Name)
+}
+
+// DamageCreatureAction subtracts a number of hitpoints from the creature with an ID
 type DamageCreatureAction struct {
-	ID, Amount int
+	ID     string
+	Amount int
+}
+
+func (a *DamageCreatureAction) validate(p *party) error {
+	return p.checkCreature(a.ID)
 }
 
 func (a *DamageCreatureAction) apply(p *party) {
-	p.EncounterCreatures[a.ID].DamageTaken += a.Amount
+	p.EncounterCreatures[p.creatureIndex(a.ID)].DamageTaken += a.Amount
 }
 
 func (a *DamageCreatureAction) undo(p *party) {
-	p.EncounterCreatures[a.ID].DamageTaken -= a.Amount
+	p.EncounterCreatures[p.creatureIndex(a.ID)].DamageTaken -= a.Amount
+}
+
+func (a *DamageCreatureAction) describe(p *party) string {
+	return describeDamage(p.EncounterCreatures[p.creatureIndex(a.ID)], a.Amount)
+}
+
+func describeDamage(c *creature.Creature, amount int) string {
+	if amount < 0 {
+		return fmt.Sprintf("%s healed %d HP", describeCreature(c), -amount)
+	}
+	return fmt.Sprintf("%s took %d damage", describeCreature(c), amount)
 }
 
 // DamageMultipleCreaturesAction is just a slice of damage creature actions
 type DamageMultipleCreaturesAction []DamageCreatureAction
 
+func (as DamageMultipleCreaturesAction) validate(p *party) error {
+	for _, a := range as {
+		if err := a.validate(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (as DamageMultipleCreaturesAction) apply(p *party) {
 	for _, a := range as {
 		a.apply(p)
@@ -55,20 +150,43 @@ func (as DamageMultipleCreaturesAction) undo(p *party) {
 	}
 }
 
-// DeleteCreatureAction deletes a creature
+func (as DamageMultipleCreaturesAction) describe(p *party) string {
+	descriptions := make([]string, len(as))
+	for i, a := range as {
+		descriptions[i] = a.describe(p)
+	}
+	return strings.Join(descriptions, "; ")
+}
+
+// DeleteCreatureAction deletes a creature. Position is where it was in the encounter, so that
+// undoing puts it back there.
 type DeleteCreatureAction struct {
-	id              int
-	deletedCreature *creature.Creature
+	ID              string
+	Position        int
+	DeletedCreature *creature.Creature
 }
 
 // NewDeleteCreatureAction creates an action that deletes a creature.
-// Restoring the creature requires keeping a reference to its whole state.
-func newDeleteCreatureAction(p *party, ID int) *DeleteCreatureAction {
-	return &DeleteCreatureAction{ID, p.EncounterCreatures[ID]}
+// Restoring the creature requires keeping a reference to its whole state. If there's no such
+// creature the action is still made, but can't be applied.
+func newDeleteCreatureAction(p *party, ID string) *DeleteCreatureAction {
+	i := p.creatureIndex(ID)
+	if i == -1 {
+		return &DeleteCreatureAction{ID, i, nil}
+	}
+	return &DeleteCreatureAction{ID, i, p.EncounterCreatures[i]}
+}
+
+func (a *DeleteCreatureAction) validate(p *party) error {
+	return p.checkCreature(a.ID)
 }
 
 func (a *DeleteCreatureAction) apply(p *party) {
-	p.EncounterCreatures = append(p.EncounterCreatures[:a.id], p.EncounterCreatures[a.id+1:]...)
+	// As with AddCreatureAction, the creature held by the action may be a stale copy
+	a.Position = p.creatureIndex(a.ID)
+	a.DeletedCreature = p.EncounterCreatures[a.Position]
+	p.EncounterCreatures = append(p.EncounterCreatures[:a.Position],
+		p.EncounterCreatures[a.Position+1:]...)
 }
 
 func (a *DeleteCreatureAction) undo(p *party) {
@@ -76,8 +194,12 @@ func (a *DeleteCreatureAction) undo(p *party) {
 	// one along to make room, then putting the deleted creature back in the same place.
 	// I googled how to do this! Unit tests stopped me making a stupid mistake...
 	p.EncounterCreatures = append(p.EncounterCreatures, nil)
-	copy(p.EncounterCreatures[a.id+1:], p.EncounterCreatures[a.id:])
-	p.EncounterCreatures[a.id] = a.deletedCreature
+	copy(p.EncounterCreatures[a.Position+1:], p.EncounterCreatures[a.Position:])
+	p.EncounterCreatures[a.Position] = a.DeletedCreature
+}
+
+func (a *DeleteCreatureAction) describe(p *party) string {
+	return describeCreature(a.DeletedCreature) + " was removed from the encounter"
 }
 
 // AddPlayerAction adds a new player to party
@@ -86,9 +208,76 @@ type AddPlayerAction struct {
 }
 
 func (a *AddPlayerAction) apply(p *party) {
-	p.Players = append(p.Players, &Player{a.Name})
+	p.Players = append(p.Players, &Player{a.Name, false})
+	p.alignInitiatives()
 }
 
 func (a *AddPlayerAction) undo(p *party) {
 	p.Players = p.Players[:len(p.Players)-1]
+	p.alignInitiatives()
+}
+
+func (a *AddPlayerAction) describe(p *party) string {
+	return a.Name + " joined the party"
+}
+
+// gameState is everything about a party that restoring a backup replaces. The name, the undo
+// history and the session log are kept.
+type gameState struct {
+	Players                   []*Player
+	RollHistory               []rollRecord
+	LastCustomRoll            string
+	EncounterCreatures        []*creature.Creature
+	PlayerHasInitiatives      []bool
+	PlayerInitiativeRolls     []int
+	CurrentEncounterCreatures []*EncounterCreature
+	EncounterNumber           int
+	Turn                      int
+}
+
+func (p *party) gameState() gameState {
+	return gameState{p.Players, p.RollHistory, p.LastCustomRoll, p.EncounterCreatures,
+		p.PlayerHasInitiatives, p.PlayerInitiativeRolls, p.CurrentEncounterCreatures,
+		p.EncounterNumber, p.Turn}
+}
+
+func (p *party) setGameState(s gameState) {
+	p.Players = s.Players
+	p.RollHistory = s.RollHistory
+	p.LastCustomRoll = s.LastCustomRoll
+	p.EncounterCreatures = s.EncounterCreatures
+	p.PlayerHasInitiatives = s.PlayerHasInitiatives
+	p.PlayerInitiativeRolls = s.PlayerInitiativeRolls
+	p.CurrentEncounterCreatures = s.CurrentEncounterCreatures
+	p.EncounterNumber = s.EncounterNumber
+	p.Turn = s.Turn
+}
+
+// RestoreAction replaces the state of the party with that of a backup
+type RestoreAction struct {
+	From               time.Time
+	Restored, Replaced gameState
+}
+
+// NewRestoreAction creates an action restoring the party to how it was in a backup taken at a
+// given time
+func NewRestoreAction(backup Party, from time.Time) *RestoreAction {
+	restored := backup.(*party).gameState()
+	// Backups from before creatures had IDs aren't migrated when they're loaded
+	ensureCreatureIDs(restored.EncounterCreatures)
+	return &RestoreAction{From: from, Restored: restored}
+}
+
+func (a *RestoreAction) apply(p *party) {
+	a.Replaced = p.gameState()
+	p.setGameState(a.Restored)
+}
+
+func (a *RestoreAction) undo(p *party) {
+	a.Restored = p.gameState()
+	p.setGameState(a.Replaced)
+}
+
+func (a *RestoreAction) describe(p *party) string {
+	return "Restored the backup from " + a.From.Format("2006-01-02 15:04")
 }
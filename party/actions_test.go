@@ -22,7 +22,7 @@ func TestDamageCreatureAction(t *testing.T) {
 	c := creature.Create("test", "foo", testDiceRoll(50))
 	p.Apply(&AddCreatureAction{c})
 	assert.Equal(t, 0, p.EncounterCreatures[0].DamageTaken)
-	a := &DamageCreatureAction{0, 20}
+	a := &DamageCreatureAction{c.ID, 20}
 	a.apply(p)
 	assert.Equal(t, 20, p.EncounterCreatures[0].DamageTaken)
 	a.undo(p)
@@ -33,7 +33,7 @@ func TestDeleteCreatureAction(t *testing.T) {
 	p := testingParty()
 	c := creature.Create("baz", "bar", testDiceRoll(1337))
 	p.Apply(&AddCreatureAction{c})
-	a := newDeleteCreatureAction(p, 0)
+	a := newDeleteCreatureAction(p, c.ID)
 	a.apply(p)
 	assert.Equal(t, []*creature.Creature{}, p.EncounterCreatures)
 	a.undo(p)
@@ -47,9 +47,42 @@ func TestDeleteOneOfMultipleCreatures(t *testing.T) {
 		cs[i] = creature.Create("test", "cret", testDiceRoll(i))
 		p.Apply(&AddCreatureAction{cs[i]})
 	}
-	a := newDeleteCreatureAction(p, 2)
+	a := newDeleteCreatureAction(p, cs[2].ID)
 	a.apply(p)
 	assert.Equal(t, []*creature.Creature{cs[0], cs[1], cs[3]}, p.EncounterCreatures)
 	a.undo(p)
 	assert.Equal(t, cs, p.EncounterCreatures)
 }
+
+func TestActionsForMissingCreatures(t *testing.T) {
+	p := testingParty()
+	c := creature.Create("test", "foo", testDiceRoll(10))
+	p.Apply(&AddCreatureAction{c})
+	assert.Error(t, p.Apply(&DamageCreatureAction{"gone", 5}))
+	assert.Error(t, p.Apply(DamageMultipleCreaturesAction{{c.ID, 5}, {"gone", 5}}))
+	assert.Equal(t, 0, p.EncounterCreatures[0].DamageTaken)
+	assert.Error(t, p.Apply(p.DeleteCreatureAction("gone")))
+	assert.Error(t, p.Apply(&AddCreatureAction{nil}))
+	assert.Len(t, p.EncounterCreatures, 1)
+	assert.NoError(t, p.Apply(p.DeleteCreatureAction(c.ID)))
+	assert.Empty(t, p.EncounterCreatures)
+}
+
+func TestCreaturesKeepTheirIDs(t *testing.T) {
+	p := testingParty()
+	cs := make([]*creature.Creature, 3)
+	for i := range cs {
+		cs[i] = creature.Create("test", "cret", testDiceRoll(10))
+		p.Apply(&AddCreatureAction{cs[i]})
+	}
+	assert.NotEqual(t, cs[0].ID, cs[1].ID)
+	// Deleting a creature doesn't change which creature the others' IDs are for
+	p.Apply(p.DeleteCreatureAction(cs[0].ID))
+	assert.NoError(t, p.Apply(&DamageCreatureAction{cs[2].ID, 4}))
+	assert.Equal(t, 4, p.Creature(cs[2].ID).DamageTaken)
+	assert.Equal(t, 0, p.Creature(cs[1].ID).DamageTaken)
+	assert.Nil(t, p.Creature(cs[0].ID))
+	p.Undo()
+	p.Undo()
+	assert.Equal(t, cs[0].ID, p.Creatures()[0].ID)
+}
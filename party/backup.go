@@ -0,0 +1,256 @@
+package party
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BackupPolicy says how many backups of each party a store keeps. A session backup is taken the
+// first time a party is saved after being loaded, and a daily backup the first time each day.
+type BackupPolicy struct {
+	Sessions, Days int
+}
+
+// DefaultBackupPolicy is the policy new file stores start with
+var DefaultBackupPolicy = BackupPolicy{10, 14}
+
+// BackupStore is a store that keeps backups of its parties
+type BackupStore interface {
+	Store
+	Backups(name string) ([]*Backup, error)
+	LoadBackup(name, backup string) (Party, error)
+}
+
+// Backup describes one backup of a party
+type Backup struct {
+	Name  string
+	Time  time.Time
+	Daily bool
+}
+
+const (
+	sessionBackupPrefix = "session-"
+	dailyBackupPrefix   = "daily-"
+	backupTimeFormat    = "20060102-150405"
+)
+
+func (s *FileStore) backupDirectory(name string) string {
+	return filepath.Join(s.directory, "backups", name)
+}
+
+func (s *FileStore) now() time.Time {
+	if s.clock != nil {
+		return s.clock()
+	}
+	return time.Now()
+}
+
+// Backups lists the backups of a party, newest first
+func (s *FileStore) Backups(name string) ([]*Backup, error) {
+	contents, err := ioutil.ReadDir(s.backupDirectory(name))
+	if os.IsNotExist(err) {
+		return []*Backup{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	backups := make([]*Backup, 0)
+	for _, fileInfo := range contents {
+		b, err := parseBackupName(fileInfo.Name())
+		if err != nil {
+			continue
+		}
+		backups = append(backups, b)
+	}
+	sortNewestFirst(backups)
+	return backups, nil
+}
+
+func sortNewestFirst(backups []*Backup) {
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Time.After(backups[j].Time) })
+}
+
+func parseBackupName(filename string) (*Backup, error) {
+	if !strings.HasSuffix(filename, partyFileSuffix) {
+		return nil, errors.New("not a party file")
+	}
+	stamp := strings.TrimSuffix(filename, partyFileSuffix)
+	daily := strings.HasPrefix(stamp, dailyBackupPrefix)
+	if daily {
+		stamp = strings.TrimPrefix(stamp, dailyBackupPrefix)
+	} else if strings.HasPrefix(stamp, sessionBackupPrefix) {
+		stamp = strings.TrimPrefix(stamp, sessionBackupPrefix)
+	} else {
+		return nil, errors.New("not a backup")
+	}
+	t, err := time.ParseInLocation(backupTimeFormat, stamp, time.Local)
+	if err != nil {
+		return nil, err
+	}
+	return &Backup{filename, t, daily}, nil
+}
+
+// LoadBackup loads a backup of a party, with the journal backed up alongside it. The loaded party
+// doesn't belong to the store, so saving it does nothing: restore it with a RestoreAction instead.
+func (s *FileStore) LoadBackup(name, backup string) (Party, error) {
+	if _, err := parseBackupName(backup); err != nil || filepath.Base(backup) != backup {
+		return nil, fmt.Errorf("'%s' isn't a backup", backup)
+	}
+	filename := filepath.Join(s.backupDirectory(name), backup)
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	p, err := decodeSnapshot(raw)
+	if err != nil {
+		return nil, err
+	}
+	err = p.upgrade(raw)
+	if err != nil {
+		return nil, err
+	}
+	records, _, err := readJournal(journalFilename(filename))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading backed up journal - %v", err)
+	}
+	err = p.replayRecords(records)
+	if err != nil {
+		return nil, err
+	}
+	p.store = discardStore{}
+	return p, nil
+}
+
+// backUpIfDue takes a session backup if there hasn't been one since the party was loaded, and a
+// daily backup if there hasn't been one today, then gets rid of any that are too old. The backups
+// are copies of the party's snapshot and journal as they are before it's saved.
+func (s *FileStore) backUpIfDue(p *party) error {
+	filename := s.filename(p.name())
+	snapshot, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		// It hasn't been saved yet, so there's nothing to back up
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	journal, err := ioutil.ReadFile(journalFilename(filename))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	now := s.now()
+	backups, err := s.Backups(p.name())
+	if err != nil {
+		return err
+	}
+	needSession, needDaily := s.BackupPolicy.due(backups, now, p.backedUp)
+	if !needDaily && !needSession {
+		return nil
+	}
+	err = os.MkdirAll(s.backupDirectory(p.name()), 0750)
+	if err != nil {
+		return err
+	}
+	if needSession {
+		err = s.writeBackup(p.name(), backupName(now, false), snapshot, journal)
+		if err != nil {
+			return err
+		}
+		p.backedUp = true
+	}
+	if needDaily {
+		err = s.writeBackup(p.name(), backupName(now, true), snapshot, journal)
+		if err != nil {
+			return err
+		}
+	}
+	return s.pruneBackups(p.name())
+}
+
+// writeBackup writes a backup of a party's snapshot, and its journal if it has one
+func (s *FileStore) writeBackup(name, backup string, snapshot, journal []byte) error {
+	filename := filepath.Join(s.backupDirectory(name), backup)
+	err := WriteFileAtomically(filename, snapshot, 0640)
+	if err != nil || journal == nil {
+		return err
+	}
+	return WriteFileAtomically(journalFilename(filename), journal, 0640)
+}
+
+// pruneBackups deletes the oldest backups beyond what the policy keeps
+func (s *FileStore) pruneBackups(name string) error {
+	backups, err := s.Backups(name)
+	if err != nil {
+		return err
+	}
+	for _, b := range s.BackupPolicy.expired(backups) {
+		filename := filepath.Join(s.backupDirectory(name), b.Name)
+		err := os.Remove(filename)
+		if err != nil {
+			log.Printf("Error removing old backup '%s' - %v", b.Name, err)
+		}
+		err = os.Remove(journalFilename(filename))
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Error removing old backup's journal '%s' - %v", b.Name, err)
+		}
+	}
+	return nil
+}
+
+// due is whether a party needs a session backup and a daily backup, given the backups it has
+// and whether it's had a session backup since it was loaded
+func (policy BackupPolicy) due(backups []*Backup, now time.Time, backedUp bool) (session,
+	daily bool) {
+	daily = policy.Days > 0
+	for _, b := range backups {
+		if b.Daily && b.Time.Format("20060102") == now.Format("20060102") {
+			daily = false
+		}
+	}
+	return policy.Sessions > 0 && !backedUp, daily
+}
+
+// expired picks out the backups beyond what the policy keeps, from backups listed newest first
+func (policy BackupPolicy) expired(backups []*Backup) []*Backup {
+	expired := make([]*Backup, 0)
+	sessions, days := 0, 0
+	for _, b := range backups {
+		keep := false
+		if b.Daily {
+			days++
+			keep = days <= policy.Days
+		} else {
+			sessions++
+			keep = sessions <= policy.Sessions
+		}
+		if !keep {
+			expired = append(expired, b)
+		}
+	}
+	return expired
+}
+
+// backupName is the name of a backup taken at a time
+func backupName(now time.Time, daily bool) string {
+	prefix := sessionBackupPrefix
+	if daily {
+		prefix = dailyBackupPrefix
+	}
+	return prefix + now.Format(backupTimeFormat) + partyFileSuffix
+}
+
+// discardStore is for parties that shouldn't be saved anywhere, like backups
+type discardStore struct{}
+
+func (discardStore) List() ([]string, error)              { return []string{}, nil }
+func (discardStore) Load(name string) (Party, error)      { return nil, ErrNoSuchParty }
+func (discardStore) Save(p Party) error                   { return nil }
+func (discardStore) Delete(name string) error             { return ErrNoSuchParty }
+func (discardStore) Rename(oldName, newName string) error { return ErrNoSuchParty }
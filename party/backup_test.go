@@ -0,0 +1,178 @@
+package party
+
+import (
+	"dnd/creature"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionAndDailyBackups(t *testing.T) {
+	d := testingDirectory(t)
+	defer os.RemoveAll(d)
+	now := time.Date(2026, 10, 1, 19, 0, 0, 0, time.Local)
+	s := NewFileStore(d)
+	s.clock = func() time.Time { return now }
+	p := New("", "heroes")
+	assert.NoError(t, s.Save(p))
+	now = now.Add(time.Minute)
+	p.AddNote("no new backups for this")
+	assert.NoError(t, p.Save())
+	backups, err := s.Backups("heroes")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(backups))
+
+	// Loading starts a new session
+	now = now.Add(time.Hour)
+	p, err = s.Load("heroes")
+	assert.NoError(t, err)
+	assert.NoError(t, p.Save())
+	backups, _ = s.Backups("heroes")
+	assert.Equal(t, 3, len(backups))
+	assert.False(t, backups[0].Daily)
+
+	// As does a new day, which also needs a daily backup
+	now = now.Add(24 * time.Hour)
+	p, _ = s.Load("heroes")
+	assert.NoError(t, p.Save())
+	backups, _ = s.Backups("heroes")
+	assert.Equal(t, 5, len(backups))
+}
+
+func TestBackupsArePruned(t *testing.T) {
+	d := testingDirectory(t)
+	defer os.RemoveAll(d)
+	now := time.Date(2026, 10, 1, 19, 0, 0, 0, time.Local)
+	s := NewFileStore(d)
+	s.clock = func() time.Time { return now }
+	s.BackupPolicy = BackupPolicy{2, 1}
+	assert.NoError(t, s.Save(New("", "heroes")))
+	for i := 0; i < 3; i++ {
+		now = now.Add(24 * time.Hour)
+		p, _ := s.Load("heroes")
+		assert.NoError(t, p.Save())
+	}
+	backups, _ := s.Backups("heroes")
+	assert.Equal(t, 3, len(backups))
+	assert.Equal(t, now, backups[0].Time)
+	daily := 0
+	for _, b := range backups {
+		if b.Daily {
+			daily++
+		}
+	}
+	assert.Equal(t, 1, daily)
+}
+
+func TestRestoreBackup(t *testing.T) {
+	d := testingDirectory(t)
+	defer os.RemoveAll(d)
+	s := NewFileStore(d)
+	p := New("", "heroes")
+	p.Apply(&AddCreatureAction{creature.Create("orc", "grom", testDiceRoll(10))})
+	assert.NoError(t, s.Save(p))
+	p.Apply(&DamageCreatureAction{p.Creatures()[0].ID, 4})
+	p.Apply(&AddCreatureAction{creature.Create("orc", "gash", testDiceRoll(10))})
+	assert.NoError(t, p.Save())
+
+	backups, _ := s.Backups("heroes")
+	backup, err := s.LoadBackup("heroes", backups[0].Name)
+	assert.NoError(t, err)
+	_, err = s.LoadBackup("heroes", "../heroes.party.gob")
+	assert.Error(t, err)
+
+	p.Apply(NewRestoreAction(backup, backups[0].Time))
+	assert.Equal(t, 1, len(p.Creatures()))
+	assert.Equal(t, 0, p.Creatures()[0].DamageTaken)
+	assert.NoError(t, p.Undo())
+	assert.Equal(t, 2, len(p.Creatures()))
+	assert.Equal(t, 4, p.Creatures()[0].DamageTaken)
+
+	// Restoring is journalled like any other action
+	assert.NoError(t, p.Redo())
+	assert.NoError(t, p.Save())
+	loaded, _ := s.Load("heroes")
+	assert.Equal(t, 1, len(loaded.Creatures()))
+	assert.NoError(t, loaded.Undo())
+	assert.Equal(t, 2, len(loaded.Creatures()))
+}
+
+func TestBackupsAreFromBeforeTheSave(t *testing.T) {
+	d := testingDirectory(t)
+	defer os.RemoveAll(d)
+	now := time.Date(2026, 10, 1, 19, 0, 0, 0, time.Local)
+	s := NewFileStore(d)
+	s.clock = func() time.Time { return now }
+	p := New("", "heroes")
+	p.Apply(&AddCreatureAction{creature.Create("orc", "grom", testDiceRoll(10))})
+	// There's nothing to back up until the party has been saved
+	assert.NoError(t, s.Save(p))
+	backups, _ := s.Backups("heroes")
+	assert.Empty(t, backups)
+	// This only goes in the journal, so the backups need it too
+	p.Apply(&DamageCreatureAction{p.Creatures()[0].ID, 4})
+	assert.NoError(t, p.Save())
+
+	now = now.Add(time.Hour)
+	p, _ = s.Load("heroes")
+	p.Apply(&DamageCreatureAction{p.Creatures()[0].ID, 2})
+	assert.NoError(t, p.Save())
+	backups, _ = s.Backups("heroes")
+	assert.Equal(t, now, backups[0].Time)
+	backup, err := s.LoadBackup("heroes", backups[0].Name)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, backup.Creatures()[0].DamageTaken)
+
+	p.Apply(NewRestoreAction(backup, backups[0].Time))
+	assert.NoError(t, p.Save())
+	loaded, _ := s.Load("heroes")
+	assert.Equal(t, 4, loaded.Creatures()[0].DamageTaken)
+}
+
+func TestDatabaseBackups(t *testing.T) {
+	s, d := openTestDatabase(t)
+	defer os.RemoveAll(d)
+	defer s.Close()
+	now := time.Date(2026, 10, 1, 19, 0, 0, 0, time.Local)
+	s.clock = func() time.Time { return now }
+	s.BackupPolicy = BackupPolicy{2, 1}
+	p := New("", "heroes")
+	p.Apply(&AddCreatureAction{creature.Create("orc", "grom", testDiceRoll(10))})
+	// There's nothing to back up until the party has been saved
+	assert.NoError(t, s.Save(p))
+	backups, err := s.Backups("heroes")
+	assert.NoError(t, err)
+	assert.Empty(t, backups)
+
+	now = now.Add(time.Minute)
+	p.Apply(&DamageCreatureAction{p.Creatures()[0].ID, 4})
+	assert.NoError(t, p.Save())
+	p.AddNote("no new backups for this")
+	assert.NoError(t, p.Save())
+	backups, _ = s.Backups("heroes")
+	assert.Len(t, backups, 2)
+	// The backups are from before the change that was saved
+	backup, err := s.LoadBackup("heroes", backups[0].Name)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, backup.Creatures()[0].DamageTaken)
+	_, err = s.LoadBackup("heroes", "session-nothing.party.gob")
+	assert.Error(t, err)
+
+	for i := 0; i < 3; i++ {
+		now = now.Add(24 * time.Hour)
+		p, _ := s.Load("heroes")
+		assert.NoError(t, p.Save())
+	}
+	backups, _ = s.Backups("heroes")
+	assert.Len(t, backups, 3)
+	assert.Equal(t, now, backups[0].Time)
+
+	// Backups go with the party when it's renamed or archived
+	assert.NoError(t, s.Rename("heroes", "legends"))
+	assert.NoError(t, s.Archive("legends"))
+	assert.NoError(t, s.Unarchive("legends"))
+	backups, _ = s.Backups("legends")
+	assert.Len(t, backups, 3)
+}
@@ -0,0 +1,51 @@
+package party
+
+// Change tells a subscriber that something has happened to a party. Seq is the journal sequence
+// number of the event, and Kind says what sort of event it was.
+type Change struct {
+	Seq  int    `json:"seq"`
+	Kind string `json:"kind"`
+}
+
+var changeKinds = map[journalEventType]string{
+	applyEvent:      "apply",
+	undoEvent:       "undo",
+	redoEvent:       "redo",
+	rollEvent:       "roll",
+	customRollEvent: "customRoll",
+	noteEvent:       "note",
+	joinCodeEvent:   "joinCode",
+}
+
+// changeBufferSize is how many changes can wait for a subscriber before it starts missing them
+const changeBufferSize = 16
+
+// Subscribe returns a channel that gets a Change every time the party changes, and a function to
+// call to stop. Subscribers that fall behind miss changes rather than holding up the party.
+func (p *party) Subscribe() (<-chan Change, func()) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.subscribers == nil {
+		p.subscribers = make(map[chan Change]bool)
+	}
+	c := make(chan Change, changeBufferSize)
+	p.subscribers[c] = true
+	return c, func() {
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+		if p.subscribers[c] {
+			delete(p.subscribers, c)
+			close(c)
+		}
+	}
+}
+
+// notify tells every subscriber about a change. The party must be locked.
+func (p *party) notify(change Change) {
+	for c := range p.subscribers {
+		select {
+		case c <- change:
+		default:
+		}
+	}
+}
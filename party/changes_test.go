@@ -0,0 +1,34 @@
+package party
+
+import (
+	"dnd/creature"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscribe(t *testing.T) {
+	p := New("", "heroes")
+	changes, unsubscribe := p.Subscribe()
+	p.Apply(&AddCreatureAction{creature.Create("orc", "grom", testDiceRoll(10))})
+	p.Undo()
+	p.Redo()
+	p.SetCustomRoll("d20")
+	p.AddNote("hello")
+	for _, kind := range []string{"apply", "undo", "redo", "customRoll", "note"} {
+		change := <-changes
+		assert.Equal(t, kind, change.Kind)
+	}
+
+	// A subscriber that doesn't keep up misses changes instead of blocking the party
+	for i := 0; i < 2*changeBufferSize; i++ {
+		p.AddNote("hello")
+	}
+	assert.Equal(t, changeBufferSize, len(changes))
+
+	unsubscribe()
+	unsubscribe()
+	p.AddNote("hello")
+	for range changes {
+	}
+}
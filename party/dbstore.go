@@ -0,0 +1,383 @@
+package party
+
+import (
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/*
+ * A DatabaseStore keeps every party in one bbolt database file. Like the directories of a
+ * FileStore, there's a bucket each for the parties in use, the archive and the trash. In those,
+ * each party has a bucket of its own, with its snapshot and a bucket of its backups:
+ *
+ *	parties/(name)/snapshot
+ *	parties/(name)/backups/session-20060102-150405.party.gob
+ *	archive/(name)/...
+ *	trash/(name)-20060102-150405/...
+ *
+ * Every save is a transaction, so there's no journal: a party is either saved or it isn't.
+ */
+
+var (
+	partiesBucket = []byte("parties")
+	archiveBucket = []byte("archive")
+	trashBucket   = []byte("trash")
+	backupsBucket = []byte("backups")
+	snapshotKey   = []byte("snapshot")
+)
+
+// DatabaseStore keeps every party in a single embedded database file, with their backups, the
+// archive and the trash. The file can only be open in one process at a time.
+type DatabaseStore struct {
+	db           *bolt.DB
+	BackupPolicy BackupPolicy
+	clock        func() time.Time
+}
+
+// OpenDatabaseStore opens the store in a file, creating the file if it doesn't exist. It fails if
+// another process has the file open.
+func OpenDatabaseStore(filename string) (*DatabaseStore, error) {
+	db, err := bolt.Open(filename, 0640, &bolt.Options{Timeout: time.Second})
+	if err == bolt.ErrTimeout {
+		return nil, fmt.Errorf("party database '%s' is in use by another process", filename)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening party database '%s' - %v", filename, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{partiesBucket, archiveBucket, trashBucket} {
+			_, err := tx.CreateBucketIfNotExists(name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error setting up party database '%s' - %v", filename, err)
+	}
+	return &DatabaseStore{db, DefaultBackupPolicy, nil}, nil
+}
+
+// Close closes the database file
+func (s *DatabaseStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *DatabaseStore) now() time.Time {
+	if s.clock != nil {
+		return s.clock()
+	}
+	return time.Now()
+}
+
+// bucketNames lists the buckets in a bucket, in alphabetical order
+func bucketNames(b *bolt.Bucket) []string {
+	names := make([]string, 0)
+	b.ForEachBucket(func(k []byte) error {
+		names = append(names, string(k))
+		return nil
+	})
+	return names
+}
+
+// List the names of the parties in the store, in alphabetical order
+func (s *DatabaseStore) List() ([]string, error) {
+	var names []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		names = bucketNames(tx.Bucket(partiesBucket))
+		return nil
+	})
+	return names, err
+}
+
+// Load a party from the store. A party saved by an older version is migrated, keeping a copy of
+// it as it was among its backups first.
+func (s *DatabaseStore) Load(name string) (Party, error) {
+	var snapshot []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(partiesBucket).Bucket([]byte(name))
+		if b == nil {
+			return ErrNoSuchParty
+		}
+		// Values are only valid during the transaction
+		snapshot = append([]byte(nil), b.Get(snapshotKey)...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	p, err := decodeSnapshot(snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding party '%s' - %v", name, err)
+	}
+	if p.Version < currentVersion {
+		err = s.db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.Bucket(partiesBucket).Bucket([]byte(name)).CreateBucketIfNotExists(
+				backupsBucket)
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte(backupName(s.now(), false)), snapshot)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error backing up party before migrating - %v", err)
+		}
+	}
+	err = p.upgrade(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	p.store = s
+	return p, nil
+}
+
+// Save a snapshot of a party into the store. The first save after the party is loaded, and the
+// first each day, backs up what was in the store before.
+func (s *DatabaseStore) Save(p Party) error {
+	pp, ok := p.(*party)
+	if !ok {
+		return fmt.Errorf("can't save %T", p)
+	}
+	pp.mutex.Lock()
+	defer pp.mutex.Unlock()
+	snapshot, err := pp.encodeSnapshot()
+	if err != nil {
+		return err
+	}
+	backedUp := pp.backedUp
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(partiesBucket).CreateBucketIfNotExists([]byte(pp.name()))
+		if err != nil {
+			return err
+		}
+		if previous := b.Get(snapshotKey); previous != nil {
+			backedUp, err = s.backUpIfDue(b, previous, backedUp)
+			if err != nil {
+				return err
+			}
+		}
+		return b.Put(snapshotKey, snapshot)
+	})
+	if err != nil {
+		return fmt.Errorf("error saving party '%s' - %v", pp.name(), err)
+	}
+	pp.backedUp = backedUp
+	pp.pending = nil
+	pp.store = s
+	return nil
+}
+
+// backUpIfDue keeps a copy of a party's previous snapshot if a backup is due, and gets rid of
+// backups that are too old. It returns whether the party has had a session backup now.
+func (s *DatabaseStore) backUpIfDue(b *bolt.Bucket, previous []byte, backedUp bool) (bool,
+	error) {
+	backups, err := b.CreateBucketIfNotExists(backupsBucket)
+	if err != nil {
+		return backedUp, err
+	}
+	now := s.now()
+	needSession, needDaily := s.BackupPolicy.due(listBackups(backups), now, backedUp)
+	if needSession {
+		err = backups.Put([]byte(backupName(now, false)), previous)
+		if err != nil {
+			return backedUp, err
+		}
+		backedUp = true
+	}
+	if needDaily {
+		err = backups.Put([]byte(backupName(now, true)), previous)
+		if err != nil {
+			return backedUp, err
+		}
+	}
+	for _, old := range s.BackupPolicy.expired(listBackups(backups)) {
+		err = backups.Delete([]byte(old.Name))
+		if err != nil {
+			return backedUp, err
+		}
+	}
+	return backedUp, nil
+}
+
+// listBackups lists the backups in a party's bucket of them, newest first
+func listBackups(b *bolt.Bucket) []*Backup {
+	backups := make([]*Backup, 0)
+	if b == nil {
+		return backups
+	}
+	b.ForEach(func(k, v []byte) error {
+		if backup, err := parseBackupName(string(k)); err == nil {
+			backups = append(backups, backup)
+		}
+		return nil
+	})
+	sortNewestFirst(backups)
+	return backups
+}
+
+// Backups lists the backups of a party, newest first
+func (s *DatabaseStore) Backups(name string) ([]*Backup, error) {
+	var backups []*Backup
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(partiesBucket).Bucket([]byte(name))
+		if b == nil {
+			return ErrNoSuchParty
+		}
+		backups = listBackups(b.Bucket(backupsBucket))
+		return nil
+	})
+	return backups, err
+}
+
+// LoadBackup loads a backup of a party. Like a FileStore's backups, the loaded party doesn't
+// belong to the store: restore it with a RestoreAction.
+func (s *DatabaseStore) LoadBackup(name, backup string) (Party, error) {
+	var snapshot []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(partiesBucket).Bucket([]byte(name))
+		if b == nil {
+			return ErrNoSuchParty
+		}
+		backups := b.Bucket(backupsBucket)
+		if backups != nil {
+			snapshot = append([]byte(nil), backups.Get([]byte(backup))...)
+		}
+		if len(snapshot) == 0 {
+			return fmt.Errorf("'%s' isn't a backup", backup)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	p, err := decodeSnapshot(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	err = p.upgrade(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	p.store = discardStore{}
+	return p, nil
+}
+
+// Delete a party from the store, with its backups
+func (s *DatabaseStore) Delete(name string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(partiesBucket).DeleteBucket([]byte(name))
+		if err == bolt.ErrBucketNotFound {
+			return ErrNoSuchParty
+		}
+		return err
+	})
+}
+
+// copyBucket copies everything in a bucket, including the buckets inside it, into another
+func copyBucket(from, to *bolt.Bucket) error {
+	return from.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return to.Put(k, v)
+		}
+		inner, err := to.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+		return copyBucket(from.Bucket(k), inner)
+	})
+}
+
+// moveParty moves a party's bucket from one bucket to another, giving it a new name on the way.
+// The snapshot still has the old name inside it.
+func moveParty(from, to *bolt.Bucket, oldName, newName string) error {
+	b := from.Bucket([]byte(oldName))
+	if b == nil {
+		return ErrNoSuchParty
+	}
+	moved, err := to.CreateBucket([]byte(newName))
+	if err != nil {
+		return err
+	}
+	err = copyBucket(b, moved)
+	if err != nil {
+		return err
+	}
+	return from.DeleteBucket([]byte(oldName))
+}
+
+// Rename a party in the store, saving it with the new name inside
+func (s *DatabaseStore) Rename(oldName, newName string) error {
+	if !ValidName(newName) {
+		return fmt.Errorf("'%s' can't be used as a party name", newName)
+	}
+	// Loading it migrates it, so that it's saved in the current format
+	p, err := s.Load(oldName)
+	if err != nil {
+		return err
+	}
+	pp := p.(*party)
+	pp.PartyName = newName
+	snapshot, err := pp.encodeSnapshot()
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		parties := tx.Bucket(partiesBucket)
+		if parties.Bucket([]byte(newName)) != nil {
+			return fmt.Errorf("there's already a party called '%s'", newName)
+		}
+		err := moveParty(parties, parties, oldName, newName)
+		if err != nil {
+			return err
+		}
+		return parties.Bucket([]byte(newName)).Put(snapshotKey, snapshot)
+	})
+}
+
+// Trash moves a party and its backups into the trash
+func (s *DatabaseStore) Trash(name string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		trashed := name + "-" + s.now().Format(backupTimeFormat)
+		return moveParty(tx.Bucket(partiesBucket), tx.Bucket(trashBucket), name, trashed)
+	})
+}
+
+// Archive moves a party into the archive, where it isn't listed any more
+func (s *DatabaseStore) Archive(name string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(partiesBucket).Bucket([]byte(name)) == nil {
+			return ErrNoSuchParty
+		}
+		if tx.Bucket(archiveBucket).Bucket([]byte(name)) != nil {
+			return fmt.Errorf("there's already an archived party called '%s'", name)
+		}
+		return moveParty(tx.Bucket(partiesBucket), tx.Bucket(archiveBucket), name, name)
+	})
+}
+
+// Unarchive moves a party back out of the archive
+func (s *DatabaseStore) Unarchive(name string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(archiveBucket).Bucket([]byte(name)) == nil {
+			return ErrNoSuchParty
+		}
+		if tx.Bucket(partiesBucket).Bucket([]byte(name)) != nil {
+			return fmt.Errorf("there's already a party called '%s'", name)
+		}
+		return moveParty(tx.Bucket(archiveBucket), tx.Bucket(partiesBucket), name, name)
+	})
+}
+
+// Archived lists the parties in the archive, in alphabetical order
+func (s *DatabaseStore) Archived() ([]string, error) {
+	var names []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		names = bucketNames(tx.Bucket(archiveBucket))
+		return nil
+	})
+	return names, err
+}
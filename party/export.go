@@ -0,0 +1,209 @@
+package party
+
+import (
+	"dnd/creature"
+	"dnd/dice"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+)
+
+// exportVersion is the version of the JSON format written by Export. Import refuses anything
+// newer than it understands.
+const exportVersion = 1
+
+// The exported types are a separate, stable description of a party rather than the party
+// struct itself, so that the gob format can change without breaking anyone's exported files.
+// Dice are written as expressions like "2d6 + 3" so that they can be edited by hand.
+
+type exportedParty struct {
+	Version    int               `json:"version"`
+	Name       string            `json:"name"`
+	Players    []exportedPlayer  `json:"players"`
+	Encounter  exportedEncounter `json:"encounter"`
+	Rolls      []exportedRoll    `json:"rolls"`
+	CustomRoll string            `json:"customRoll"`
+	Log        []exportedLog     `json:"log"`
+}
+
+type exportedPlayer struct {
+	Name          string `json:"name"`
+	Locked        bool   `json:"locked,omitempty"`
+	HasInitiative bool   `json:"hasInitiative"`
+	Initiative    int    `json:"initiative"`
+}
+
+type exportedEncounter struct {
+	Number      int                  `json:"number"`
+	Turn        int                  `json:"turn,omitempty"`
+	Creatures   []exportedCreature   `json:"creatures"`
+	Initiatives []exportedInitiative `json:"initiatives"`
+}
+
+type exportedCreature struct {
+	ID           string `json:"id,omitempty"`
+	Type         string `json:"type"`
+	Name         string `json:"name"`
+	HitDice      string `json:"hitDice"`
+	RolledHealth int    `json:"rolledHealth"`
+	DamageTaken  int    `json:"damageTaken"`
+}
+
+type exportedInitiative struct {
+	Name           string `json:"name"`
+	InitiativeDice string `json:"initiativeDice"`
+	Initiative     int    `json:"initiative"`
+}
+
+type exportedRoll struct {
+	Roll            string   `json:"roll"`
+	PositiveResults [][]uint `json:"positiveResults"`
+	NegativeResults [][]uint `json:"negativeResults"`
+	Sum             int      `json:"sum"`
+	Visibility      string   `json:"visibility,omitempty"`
+	Player          string   `json:"player,omitempty"`
+	ChatAuthor      string   `json:"chatAuthor,omitempty"`
+	Label           string   `json:"label,omitempty"`
+}
+
+type exportedLog struct {
+	Time      time.Time `json:"time"`
+	Encounter int       `json:"encounter"`
+	Text      string    `json:"text"`
+	IsNote    bool      `json:"isNote,omitempty"`
+}
+
+func parseExportedDice(s string) (*dice.Roll, error) {
+	roll, err := dice.ParseRollString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dice '%s' - %v", s, err)
+	}
+	return roll, nil
+}
+
+// Export writes the party as human readable JSON. The undo history isn't included.
+func (p *party) Export(w io.Writer) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	e := exportedParty{
+		Version:    exportVersion,
+		Name:       p.name(),
+		Players:    make([]exportedPlayer, len(p.Players)),
+		Rolls:      make([]exportedRoll, len(p.RollHistory)),
+		CustomRoll: p.LastCustomRoll,
+		Log:        make([]exportedLog, len(p.Log)),
+	}
+	for i, player := range p.Players {
+		e.Players[i].Name = player.Name
+		e.Players[i].Locked = player.Locked
+		// Players added since initiative was last set don't have entries yet
+		if i < len(p.PlayerHasInitiatives) && i < len(p.PlayerInitiativeRolls) {
+			e.Players[i].HasInitiative = p.PlayerHasInitiatives[i]
+			e.Players[i].Initiative = p.PlayerInitiativeRolls[i]
+		}
+	}
+	e.Encounter.Number = p.EncounterNumber
+	e.Encounter.Turn = p.Turn
+	e.Encounter.Creatures = make([]exportedCreature, len(p.EncounterCreatures))
+	for i, c := range p.EncounterCreatures {
+		e.Encounter.Creatures[i] = exportedCreature{
+			c.ID, c.Type.Name, c.Name, c.Type.HitDice.Expression(), c.RolledHealth, c.DamageTaken}
+	}
+	e.Encounter.Initiatives = make([]exportedInitiative, len(p.CurrentEncounterCreatures))
+	for i, c := range p.CurrentEncounterCreatures {
+		e.Encounter.Initiatives[i] = exportedInitiative{
+			c.Name, c.InitiativeDice.Expression(), c.Initiative}
+	}
+	for i, record := range p.RollHistory {
+		r := record.Result
+		e.Rolls[i] = exportedRoll{r.Roll.Expression(), r.PositiveResults, r.NegativeResults,
+			r.Sum, "", record.Player, record.ChatAuthor, record.Label}
+		if record.Visibility != PublicRoll {
+			e.Rolls[i].Visibility = record.Visibility.String()
+		}
+	}
+	for i, l := range p.Log {
+		e.Log[i] = exportedLog{l.Time, l.Encounter, l.Text, l.IsNote}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(&e)
+}
+
+// ValidName is whether a party name can be used as the start of its file name
+func ValidName(name string) bool {
+	return name != "" && name != "." && name != ".." && filepath.Base(name) == name
+}
+
+// Import reads a party written by Export, to be saved in the given directory. The party
+// starts with an empty undo history, and isn't saved until Save is called.
+func Import(r io.Reader, directory string) (Party, error) {
+	var e exportedParty
+	err := json.NewDecoder(r).Decode(&e)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding party - %v", err)
+	}
+	if e.Version < 1 || e.Version > exportVersion {
+		return nil, fmt.Errorf("can't import version %d, only up to %d", e.Version, exportVersion)
+	}
+	if !ValidName(e.Name) {
+		return nil, fmt.Errorf("'%s' isn't a valid party name", e.Name)
+	}
+	p := New(directory, e.Name).(*party)
+	for _, player := range e.Players {
+		p.Players = append(p.Players, &Player{player.Name, player.Locked})
+		p.PlayerHasInitiatives = append(p.PlayerHasInitiatives, player.HasInitiative)
+		p.PlayerInitiativeRolls = append(p.PlayerInitiativeRolls, player.Initiative)
+	}
+	p.EncounterNumber = e.Encounter.Number
+	p.Turn = e.Encounter.Turn
+	IDs := make(map[string]bool)
+	for _, c := range e.Encounter.Creatures {
+		hitDice, err := parseExportedDice(c.HitDice)
+		if err != nil {
+			return nil, fmt.Errorf("creature '%s' has %v", c.Name, err)
+		}
+		if c.ID != "" && !creature.ValidID(c.ID) {
+			return nil, fmt.Errorf("creature '%s' has a bad ID '%s'", c.Name, c.ID)
+		}
+		if c.ID != "" && IDs[c.ID] {
+			return nil, fmt.Errorf("more than one creature has the ID '%s'", c.ID)
+		}
+		IDs[c.ID] = true
+		p.EncounterCreatures = append(p.EncounterCreatures, &creature.Creature{
+			c.ID, &creature.Type{c.Type, hitDice}, c.Name, c.RolledHealth, c.DamageTaken})
+	}
+	// Creatures typed in by hand won't have IDs
+	ensureCreatureIDs(p.EncounterCreatures)
+	for _, c := range e.Encounter.Initiatives {
+		initiativeDice, err := parseExportedDice(c.InitiativeDice)
+		if err != nil {
+			return nil, fmt.Errorf("initiative for '%s' has %v", c.Name, err)
+		}
+		p.CurrentEncounterCreatures = append(p.CurrentEncounterCreatures,
+			&EncounterCreature{c.Name, *initiativeDice, c.Initiative})
+	}
+	for _, r := range e.Rolls {
+		roll, err := parseExportedDice(r.Roll)
+		if err != nil {
+			return nil, fmt.Errorf("roll has %v", err)
+		}
+		visibility := PublicRoll
+		if r.Visibility != "" {
+			visibility, err = ParseRollVisibility(r.Visibility)
+			if err != nil {
+				return nil, err
+			}
+		}
+		p.RollHistory = append(p.RollHistory, rollRecord{
+			dice.RollResult{roll, r.PositiveResults, r.NegativeResults, r.Sum}, visibility,
+			r.Player, r.ChatAuthor, r.Label})
+	}
+	p.LastCustomRoll = e.CustomRoll
+	for _, l := range e.Log {
+		p.Log = append(p.Log, LogEntry{l.Time, l.Encounter, l.Text, l.IsNote})
+	}
+	return p, nil
+}
@@ -0,0 +1,85 @@
+package party
+
+import (
+	"bytes"
+	"dnd/creature"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func exportTestParty(t *testing.T, directory string) Party {
+	p := New(directory, "exported")
+	p.Apply(&AddPlayerAction{"alice"})
+	p.Apply(&AddCreatureAction{creature.Create("orc", "grom", testDiceRoll(10))})
+	hitDice, err := parseExportedDice("d6 - d4 - d8 + 2")
+	assert.NoError(t, err)
+	p.Apply(&AddCreatureAction{creature.Create("wolf", "", hitDice)})
+	p.Apply(&DamageCreatureAction{p.Creatures()[0].ID, 3})
+	p.AddChatRoll("colin", "stealth", hitDice.Simulate())
+	p.AddRoll(hitDice.Simulate(), RevealLaterRoll)
+	p.SetCustomRoll("2d6 + 1")
+	p.AddNote("a note")
+	pp := p.(*party)
+	pp.PlayerHasInitiatives = []bool{true}
+	pp.PlayerInitiativeRolls = []int{14}
+	pp.CurrentEncounterCreatures = []*EncounterCreature{{"grom", *testDiceRoll(3), 12}}
+	return p
+}
+
+func exportString(t *testing.T, p Party) string {
+	var b bytes.Buffer
+	err := p.Export(&b)
+	assert.NoError(t, err)
+	return b.String()
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	p := exportTestParty(t, "")
+	exported := exportString(t, p)
+	imported, err := Import(strings.NewReader(exported), "")
+	assert.NoError(t, err)
+	assert.Equal(t, exported, exportString(t, imported))
+	assert.Equal(t, "exported", imported.Name())
+	assert.Equal(t, 3, imported.Creatures()[0].DamageTaken)
+	assert.Equal(t, p.Rolls()[0].Sum, imported.Rolls()[0].Sum)
+	assert.Equal(t, RevealLaterRoll, imported.RecordedRolls()[0].Visibility)
+	assert.Equal(t, "colin", imported.RecordedRolls()[1].ChatAuthor)
+	assert.Equal(t, "stealth", imported.RecordedRolls()[1].Label)
+	assert.False(t, imported.CanUndo())
+}
+
+func TestExportMatchesGob(t *testing.T) {
+	d := testingDirectory(t)
+	defer os.RemoveAll(d)
+	p := exportTestParty(t, d)
+	loaded := saveAndLoad(t, p)
+	assert.Equal(t, exportString(t, p), exportString(t, loaded))
+
+	importDirectory := testingDirectory(t)
+	defer os.RemoveAll(importDirectory)
+	imported, err := Import(strings.NewReader(exportString(t, p)), importDirectory)
+	assert.NoError(t, err)
+	reloaded := saveAndLoad(t, imported)
+	assert.Equal(t, exportString(t, p), exportString(t, reloaded))
+}
+
+func TestImportRejectsBadFiles(t *testing.T) {
+	_, err := Import(strings.NewReader(`{"version": 2, "name": "future"}`), "")
+	assert.Error(t, err)
+	_, err = Import(strings.NewReader(`{"version": 1, "name": "../escape"}`), "")
+	assert.Error(t, err)
+	_, err = Import(strings.NewReader(`{"version": 1, "name": "x",
+		"encounter": {"creatures": [{"type": "orc", "hitDice": "2x6"}]}}`), "")
+	assert.Error(t, err)
+	_, err = Import(strings.NewReader(`{"version": 1, "name": "x", "encounter": {"creatures": [
+		{"id": "a1", "type": "orc", "hitDice": "2d6"}, {"id": "a1", "type": "orc", "hitDice": "2d6"}]}}`), "")
+	assert.Error(t, err)
+	_, err = Import(strings.NewReader(`{"version": 1, "name": "x", "encounter": {"creatures": [
+		{"id": "../Orc", "type": "orc", "hitDice": "2d6"}]}}`), "")
+	assert.Error(t, err)
+	_, err = Import(strings.NewReader(`not json`), "")
+	assert.Error(t, err)
+}
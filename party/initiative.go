@@ -0,0 +1,165 @@
+package party
+
+import (
+	"encoding/gob"
+	"fmt"
+	"sort"
+)
+
+func init() {
+	gob.Register(&InitiativeAction{})
+	gob.Register(&NextTurnAction{})
+}
+
+// InitiativeChange is what the DM entered on the initiative form. Players has an entry for each
+// of the party's players, in order. NewPlayer and NewCreature are nil when nobody was added.
+type InitiativeChange struct {
+	Players     []*CreatureInitiative
+	NewPlayer   *CreatureInitiative
+	NewCreature *EncounterCreature
+}
+
+// initiativeState is everything that decides the turn order
+type initiativeState struct {
+	Players                   []*Player
+	PlayerHasInitiatives      []bool
+	PlayerInitiativeRolls     []int
+	CurrentEncounterCreatures []*EncounterCreature
+	Turn                      int
+}
+
+// alignInitiatives makes sure every player has an entry for their initiative, and nobody else
+func (p *party) alignInitiatives() {
+	for len(p.PlayerHasInitiatives) < len(p.Players) {
+		p.PlayerHasInitiatives = append(p.PlayerHasInitiatives, false)
+	}
+	for len(p.PlayerInitiativeRolls) < len(p.Players) {
+		p.PlayerInitiativeRolls = append(p.PlayerInitiativeRolls, 0)
+	}
+	p.PlayerHasInitiatives = p.PlayerHasInitiatives[:len(p.Players)]
+	p.PlayerInitiativeRolls = p.PlayerInitiativeRolls[:len(p.Players)]
+}
+
+func (p *party) initiativeState() initiativeState {
+	return initiativeState{p.Players, p.PlayerHasInitiatives, p.PlayerInitiativeRolls,
+		p.CurrentEncounterCreatures, p.Turn}
+}
+
+func (p *party) setInitiativeState(s initiativeState) {
+	p.Players = s.Players
+	p.PlayerHasInitiatives = s.PlayerHasInitiatives
+	p.PlayerInitiativeRolls = s.PlayerInitiativeRolls
+	p.CurrentEncounterCreatures = s.CurrentEncounterCreatures
+	p.Turn = s.Turn
+}
+
+// InitiativeAction sets everyone's initiative, and starts the turn order from the top
+type InitiativeAction struct {
+	Set, Replaced initiativeState
+}
+
+// InitiativeAction creates an action making a change to the initiatives
+func (p *party) InitiativeAction(c InitiativeChange) (*InitiativeAction, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if len(c.Players) != len(p.Players) {
+		return nil, fmt.Errorf("got initiatives for %d players, but there are %d",
+			len(c.Players), len(p.Players))
+	}
+	s := initiativeState{
+		make([]*Player, 0, len(p.Players)+1),
+		make([]bool, 0, len(p.Players)+1),
+		make([]int, 0, len(p.Players)+1),
+		append([]*EncounterCreature(nil), p.CurrentEncounterCreatures...),
+		0}
+	for i, player := range p.Players {
+		s.Players = append(s.Players, player)
+		s.PlayerHasInitiatives = append(s.PlayerHasInitiatives, c.Players[i].HasInitiative)
+		s.PlayerInitiativeRolls = append(s.PlayerInitiativeRolls, c.Players[i].Initiative)
+	}
+	if c.NewPlayer != nil {
+		s.Players = append(s.Players, &Player{c.NewPlayer.Name, false})
+		s.PlayerHasInitiatives = append(s.PlayerHasInitiatives, c.NewPlayer.HasInitiative)
+		s.PlayerInitiativeRolls = append(s.PlayerInitiativeRolls, c.NewPlayer.Initiative)
+	}
+	if c.NewCreature != nil {
+		s.CurrentEncounterCreatures = append(s.CurrentEncounterCreatures, c.NewCreature)
+	}
+	return &InitiativeAction{Set: s}, nil
+}
+
+func (a *InitiativeAction) apply(p *party) {
+	a.Replaced = p.initiativeState()
+	p.setInitiativeState(a.Set)
+}
+
+func (a *InitiativeAction) undo(p *party) {
+	p.setInitiativeState(a.Replaced)
+}
+
+func (a *InitiativeAction) describe(p *party) string {
+	return "Initiative was rolled"
+}
+
+// NextTurnAction moves on to whoever is next in the turn order
+type NextTurnAction struct {
+	Previous int
+}
+
+func (a *NextTurnAction) apply(p *party) {
+	a.Previous = p.Turn
+	p.Turn++
+	if p.Turn >= len(p.turnOrder()) {
+		p.Turn = 0
+	}
+}
+
+func (a *NextTurnAction) undo(p *party) {
+	p.Turn = a.Previous
+}
+
+func (a *NextTurnAction) describe(p *party) string {
+	order := p.turnOrder()
+	if len(order) == 0 {
+		return "Nobody has rolled initiative"
+	}
+	return fmt.Sprintf("It's %s's turn", order[p.currentTurn()].Name)
+}
+
+// turnOrder is everyone with an initiative, highest first. Players go before creatures with the
+// same initiative.
+func (p *party) turnOrder() []*CreatureInitiative {
+	order := make([]*CreatureInitiative, 0, len(p.Players)+len(p.CurrentEncounterCreatures))
+	for i, player := range p.Players {
+		if i < len(p.PlayerHasInitiatives) && i < len(p.PlayerInitiativeRolls) &&
+			p.PlayerHasInitiatives[i] {
+			order = append(order, &CreatureInitiative{player.Name, true, p.PlayerInitiativeRolls[i]})
+		}
+	}
+	for _, c := range p.CurrentEncounterCreatures {
+		order = append(order, &CreatureInitiative{c.Name, true, c.Initiative})
+	}
+	sort.SliceStable(order, func(i, j int) bool { return order[i].Initiative > order[j].Initiative })
+	return order
+}
+
+func (p *party) currentTurn() int {
+	if p.Turn >= len(p.turnOrder()) {
+		return 0
+	}
+	return p.Turn
+}
+
+// TurnOrder is everyone taking part in the fight, in the order they take their turns
+func (p *party) TurnOrder() []*CreatureInitiative {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.turnOrder()
+}
+
+// CurrentTurn is the position in the turn order of whoever's turn it is
+func (p *party) CurrentTurn() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.currentTurn()
+}
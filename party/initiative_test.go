@@ -0,0 +1,58 @@
+package party
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTurnOrder(t *testing.T) {
+	p := New("", "heroes")
+	p.Apply(&AddPlayerAction{"Anya"})
+	p.Apply(&AddPlayerAction{"Bram"})
+	p.Apply(&AddPlayerAction{"Cass"})
+	a, err := p.InitiativeAction(InitiativeChange{
+		[]*CreatureInitiative{{"Anya", true, 12}, {"Bram", false, 0}, {"Cass", true, 18}},
+		&CreatureInitiative{"Dell", true, 12},
+		&EncounterCreature{"Goblins", *testDiceRoll(12), 12}})
+	assert.NoError(t, err)
+	assert.NoError(t, p.Apply(a))
+
+	names := func() []string {
+		order := p.TurnOrder()
+		n := make([]string, len(order))
+		for i, c := range order {
+			n[i] = c.Name
+		}
+		return n
+	}
+	assert.Equal(t, []string{"Cass", "Anya", "Dell", "Goblins"}, names())
+	assert.Equal(t, 0, p.CurrentTurn())
+	for i := 1; i <= 4; i++ {
+		p.Apply(&NextTurnAction{})
+		assert.Equal(t, i%4, p.CurrentTurn())
+	}
+	p.Undo()
+	assert.Equal(t, 3, p.CurrentTurn())
+	assert.Equal(t, "Undid: It's Cass's turn", p.SessionLog()[len(p.SessionLog())-1].Text)
+
+	// Three more turns, then the initiatives themselves
+	for i := 0; i < 4; i++ {
+		p.Undo()
+	}
+	assert.Equal(t, 0, len(p.TurnOrder()))
+	assert.Equal(t, 3, len(p.PlayerInitiatives()))
+
+	_, err = p.InitiativeAction(InitiativeChange{Players: []*CreatureInitiative{}})
+	assert.Error(t, err)
+}
+
+func TestOldPlayersWithoutInitiatives(t *testing.T) {
+	p := testingParty()
+	p.Players = []*Player{{"Anya", false}, {"Bram", false}}
+	assert.Equal(t, 2, len(p.PlayerInitiatives()))
+	p.Apply(&AddPlayerAction{"Cass"})
+	assert.Equal(t, 3, len(p.PlayerHasInitiatives))
+	p.Undo()
+	assert.Equal(t, 2, len(p.PlayerInitiativeRolls))
+}
@@ -0,0 +1,353 @@
+package party
+
+import (
+	"bytes"
+	"dnd/dice"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/*
+ * A party is stored as two files. The .party.gob file is a snapshot of the whole party, and the
+ * .party.journal file next to it is an append-only list of everything that has happened to it.
+ * Loading takes the snapshot and replays whatever the journal has that the snapshot doesn't.
+ *
+ * The journal is never truncated. Its first record is a copy of the snapshot it was started from,
+ * so replaying the whole thing gets the party back to how it was at any point since.
+ *
+ * Each record is a big-endian uint32 length followed by that many bytes of gob, encoded on its
+ * own so that records can be appended by separate processes and a torn write only loses the last.
+ */
+
+// snapshotInterval is how many journal records are written between fresh snapshots
+const snapshotInterval = 50
+
+type journalEventType int
+
+const (
+	baseEvent journalEventType = iota
+	applyEvent
+	undoEvent
+	redoEvent
+	rollEvent
+	customRollEvent
+	noteEvent
+	joinCodeEvent
+)
+
+type journalRecord struct {
+	Seq        int
+	Time       time.Time
+	Type       journalEventType
+	Action     Action
+	Roll       dice.RollResult
+	Visibility RollVisibility
+	Text       string
+	// ChatAuthor and Label are who made a roll in a chat, and what for
+	ChatAuthor, Label string
+	Snapshot          []byte
+}
+
+func journalFilename(partyFilename string) string {
+	return partyFilename[:len(partyFilename)-len(".gob")] + ".journal"
+}
+
+func encodeRecord(r *journalRecord) ([]byte, error) {
+	var b bytes.Buffer
+	b.Write([]byte{0, 0, 0, 0})
+	err := gob.NewEncoder(&b).Encode(r)
+	if err != nil {
+		return nil, err
+	}
+	encoded := b.Bytes()
+	binary.BigEndian.PutUint32(encoded, uint32(len(encoded)-4))
+	return encoded, nil
+}
+
+// record adds an event to the journal records waiting for the next save. The record is encoded
+// immediately, as actions hold pointers into the party that later events will change.
+func (p *party) record(r journalRecord) {
+	if p.replaying {
+		return
+	}
+	p.JournalSeq++
+	r.Seq = p.JournalSeq
+	r.Time = time.Now()
+	p.notify(Change{r.Seq, changeKinds[r.Type]})
+	encoded, err := encodeRecord(&r)
+	if err != nil {
+		// This only happens for an action type that hasn't been registered with gob
+		log.Printf("Error encoding journal record - %v. Snapshotting at next save instead.", err)
+		p.snapshotSeq = -snapshotInterval
+		return
+	}
+	p.pending = append(p.pending, encoded)
+}
+
+// readJournal reads every complete record in the journal. It also returns the length of the
+// journal up to the end of the last complete record, so that a torn write can be cut off.
+func readJournal(filename string) ([]*journalRecord, int64, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	records := make([]*journalRecord, 0)
+	var offset int64
+	for {
+		remaining := data[offset:]
+		if len(remaining) < 4 {
+			break
+		}
+		length := int64(binary.BigEndian.Uint32(remaining))
+		if int64(len(remaining)-4) < length {
+			break
+		}
+		var r journalRecord
+		err := gob.NewDecoder(bytes.NewReader(remaining[4 : 4+length])).Decode(&r)
+		if err != nil {
+			break
+		}
+		records = append(records, &r)
+		offset += 4 + length
+	}
+	return records, offset, nil
+}
+
+// replay repeats a recorded event, without recording it again
+func (p *party) replay(r *journalRecord) error {
+	p.replaying = true
+	p.replayTime = r.Time
+	defer func() {
+		p.replaying = false
+		p.replayTime = time.Time{}
+	}()
+	var err error
+	switch r.Type {
+	case baseEvent:
+	case applyEvent:
+		err = p.Apply(r.Action)
+	case undoEvent:
+		err = p.Undo()
+	case redoEvent:
+		err = p.Redo()
+	case rollEvent:
+		p.mutex.Lock()
+		p.addRoll(r.Roll, r.Visibility, rollMaker{r.Text, r.ChatAuthor, r.Label})
+		p.mutex.Unlock()
+	case customRollEvent:
+		p.SetCustomRoll(r.Text)
+	case joinCodeEvent:
+		p.PlayerJoinCode = r.Text
+	case noteEvent:
+		p.AddNote(r.Text)
+	default:
+		err = fmt.Errorf("unknown journal event type %d", r.Type)
+	}
+	p.JournalSeq = r.Seq
+	return err
+}
+
+// replayJournal brings a freshly loaded snapshot up to date with its journal
+func (p *party) replayJournal(filename string) error {
+	journal := journalFilename(filename)
+	records, length, err := readJournal(journal)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading journal - %v", err)
+	}
+	fi, err := os.Stat(journal)
+	if err == nil && fi.Size() > length {
+		log.Printf("Journal '%s' ends with an incomplete record, cutting it off", journal)
+		err = os.Truncate(journal, length)
+		if err != nil {
+			return fmt.Errorf("error truncating journal - %v", err)
+		}
+	}
+	return p.replayRecords(records)
+}
+
+// replayRecords replays the journal records that came after the party's snapshot
+func (p *party) replayRecords(records []*journalRecord) error {
+	for _, r := range records {
+		if r.Seq <= p.JournalSeq {
+			continue
+		}
+		err := p.replay(r)
+		if err != nil {
+			return fmt.Errorf("error replaying journal record %d - %v", r.Seq, err)
+		}
+	}
+	return nil
+}
+
+// LoadAt recovers a party as it was at a point in time, by replaying its journal from the start.
+// The journal's snapshot is migrated like any other. Saving the recovered party starts a new
+// journal, keeping the old one alongside it.
+func LoadAt(filename string, t time.Time) (Party, error) {
+	records, _, err := readJournal(journalFilename(filename))
+	if err != nil {
+		return nil, fmt.Errorf("error reading journal - %v", err)
+	}
+	if len(records) == 0 || records[0].Type != baseEvent {
+		return nil, errors.New("journal doesn't start with a snapshot")
+	}
+	if t.Before(records[0].Time) {
+		return nil, fmt.Errorf("journal only goes back to %v", records[0].Time)
+	}
+	p, err := decodeSnapshot(records[0].Snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding journal's snapshot - %v", err)
+	}
+	err = p.upgrade(records[0].Snapshot)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range records[1:] {
+		if r.Time.After(t) {
+			break
+		}
+		err := p.replay(r)
+		if err != nil {
+			return nil, fmt.Errorf("error replaying journal record %d - %v", r.Seq, err)
+		}
+	}
+	p.Filename = filename
+	p.restartJournal = true
+	return p, nil
+}
+
+func appendToFile(filename string, records [][]byte) error {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	for _, r := range records {
+		_, err = file.Write(r)
+		if err != nil {
+			file.Close()
+			return err
+		}
+	}
+	err = file.Sync()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// WriteFileAtomically writes a file so that it is either completely written or not at all, by
+// writing to a temporary file and then renaming it.
+func WriteFileAtomically(filename string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(filename)
+	tmp, err := ioutil.TempFile(dir, filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	// Make sure the rename itself has hit the disk
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (p *party) encodeSnapshot() ([]byte, error) {
+	var b bytes.Buffer
+	err := gob.NewEncoder(&b).Encode(p)
+	return b.Bytes(), err
+}
+
+func (p *party) writeSnapshot() error {
+	snapshot, err := p.encodeSnapshot()
+	if err != nil {
+		return fmt.Errorf("error encoding snapshot - %v", err)
+	}
+	err = WriteFileAtomically(p.Filename, snapshot, 0640)
+	if err != nil {
+		return fmt.Errorf("error writing snapshot - %v", err)
+	}
+	p.snapshotSeq = p.JournalSeq
+	return nil
+}
+
+// startJournal writes a snapshot, and starts a journal with a copy of it. Any existing journal is
+// moved out of the way rather than deleted.
+func (p *party) startJournal() error {
+	// The snapshot goes first: a snapshot without a journal is fine, the other way round isn't
+	err := p.writeSnapshot()
+	if err != nil {
+		return err
+	}
+	journal := journalFilename(p.Filename)
+	if _, err := os.Stat(journal); err == nil {
+		old := fmt.Sprintf("%s.%d", journal, time.Now().Unix())
+		err := os.Rename(journal, old)
+		if err != nil {
+			return fmt.Errorf("error moving old journal - %v", err)
+		}
+		log.Printf("Moved old journal to '%s'", old)
+	}
+	snapshot, err := p.encodeSnapshot()
+	if err != nil {
+		return fmt.Errorf("error encoding snapshot - %v", err)
+	}
+	base, err := encodeRecord(&journalRecord{Seq: p.JournalSeq, Time: time.Now(), Type: baseEvent,
+		Snapshot: snapshot})
+	if err != nil {
+		return fmt.Errorf("error encoding journal base - %v", err)
+	}
+	err = appendToFile(journal, [][]byte{base})
+	if err != nil {
+		return fmt.Errorf("error starting journal - %v", err)
+	}
+	p.pending = nil
+	p.restartJournal = false
+	return nil
+}
+
+// saveFile appends everything that has happened since the last save to the party's journal,
+// writing a new snapshot every so often so that loading doesn't have to replay too much.
+func (p *party) saveFile() error {
+	if _, err := os.Stat(journalFilename(p.Filename)); os.IsNotExist(err) || p.restartJournal {
+		return p.startJournal()
+	}
+	if len(p.pending) > 0 {
+		err := appendToFile(journalFilename(p.Filename), p.pending)
+		if err != nil {
+			return fmt.Errorf("error appending to journal - %v", err)
+		}
+		p.pending = nil
+	}
+	if p.JournalSeq-p.snapshotSeq >= snapshotInterval {
+		return p.writeSnapshot()
+	}
+	return nil
+}
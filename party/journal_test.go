@@ -0,0 +1,135 @@
+package party
+
+import (
+	"dnd/creature"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testingDirectory(t *testing.T) string {
+	d, err := ioutil.TempDir("", "encounters")
+	if err != nil {
+		t.Fatalf("Couldn't create temporary directory - %v", err)
+	}
+	return d
+}
+
+func TestJournalReplay(t *testing.T) {
+	d := testingDirectory(t)
+	defer os.RemoveAll(d)
+	p := New(d, "journal").(*party)
+	assert.NoError(t, p.Save())
+	snapshot, _ := ioutil.ReadFile(p.Filename)
+
+	p.Apply(&AddCreatureAction{creature.Create("orc", "grom", testDiceRoll(10))})
+	p.Apply(&DamageCreatureAction{p.Creatures()[0].ID, 3})
+	p.AddRoll(testDiceRoll(4).Simulate(), PublicRoll)
+	p.AddChatRoll("colin", "stealth", testDiceRoll(5).Simulate())
+	p.SetCustomRoll("2d6")
+	p.AddNote("grom is angry")
+	p.Undo()
+	assert.NoError(t, p.Save())
+
+	// Nothing but the journal should have been written
+	unchanged, _ := ioutil.ReadFile(p.Filename)
+	assert.Equal(t, snapshot, unchanged)
+
+	loaded := saveAndLoad(t, p)
+	assert.Equal(t, 0, loaded.Creatures()[0].DamageTaken)
+	assert.Equal(t, 2, len(loaded.Rolls()))
+	assert.Equal(t, "colin (chat)", loaded.RecordedRolls()[0].Who())
+	assert.Equal(t, "stealth", loaded.RecordedRolls()[0].Label)
+	assert.Equal(t, "2d6", loaded.CustomRoll())
+	assert.True(t, loaded.CanRedo())
+	assert.Equal(t, len(p.SessionLog()), len(loaded.SessionLog()))
+	assert.Equal(t, p.SessionLog()[0].Time.Unix(), loaded.SessionLog()[0].Time.Unix())
+	assert.Equal(t, p.JournalSeq, loaded.(*party).JournalSeq)
+}
+
+func TestTruncatedJournalIsCutOff(t *testing.T) {
+	d := testingDirectory(t)
+	defer os.RemoveAll(d)
+	p := New(d, "torn").(*party)
+	assert.NoError(t, p.Save())
+	p.Apply(&AddCreatureAction{creature.Create("orc", "grom", testDiceRoll(10))})
+	p.Apply(&DamageCreatureAction{p.Creatures()[0].ID, 3})
+	assert.NoError(t, p.Save())
+	journal := journalFilename(p.Filename)
+	fi, _ := os.Stat(journal)
+	os.Truncate(journal, fi.Size()-5)
+
+	f, _ := os.Open(p.Filename)
+	loaded, err := Load(f)
+	f.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(loaded.Creatures()))
+	assert.Equal(t, 0, loaded.Creatures()[0].DamageTaken)
+
+	// Anything appended after the cut should still be readable
+	loaded.Apply(&DamageCreatureAction{loaded.Creatures()[0].ID, 5})
+	reloaded := saveAndLoad(t, loaded)
+	assert.Equal(t, 5, reloaded.Creatures()[0].DamageTaken)
+}
+
+func TestPeriodicSnapshot(t *testing.T) {
+	d := testingDirectory(t)
+	defer os.RemoveAll(d)
+	p := New(d, "snapshots").(*party)
+	assert.NoError(t, p.Save())
+	for i := 0; i < snapshotInterval; i++ {
+		p.AddRoll(testDiceRoll(i).Simulate(), PublicRoll)
+		assert.NoError(t, p.Save())
+	}
+	assert.Equal(t, p.JournalSeq, p.snapshotSeq)
+	f, _ := os.Open(p.Filename)
+	defer f.Close()
+	loaded, err := Load(f)
+	assert.NoError(t, err)
+	assert.Equal(t, snapshotInterval, len(loaded.Rolls()))
+}
+
+func TestLoadAt(t *testing.T) {
+	d := testingDirectory(t)
+	defer os.RemoveAll(d)
+	p := New(d, "history").(*party)
+	assert.NoError(t, p.Save())
+	p.Apply(&AddCreatureAction{creature.Create("orc", "grom", testDiceRoll(10))})
+	assert.NoError(t, p.Save())
+	time.Sleep(10 * time.Millisecond)
+	before := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	p.Apply(&DamageCreatureAction{p.Creatures()[0].ID, 7})
+	assert.NoError(t, p.Save())
+
+	recovered, err := LoadAt(p.Filename, before)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, recovered.Creatures()[0].DamageTaken)
+
+	_, err = LoadAt(p.Filename, before.Add(-time.Hour))
+	assert.Error(t, err)
+
+	// Saving the recovered party makes it the current one
+	reloaded := saveAndLoad(t, recovered)
+	assert.Equal(t, 0, reloaded.Creatures()[0].DamageTaken)
+}
+
+func TestLoadAtMigrates(t *testing.T) {
+	d := testingDirectory(t)
+	defer os.RemoveAll(d)
+	snapshot, err := ioutil.ReadFile(filepath.Join("testdata", "v2.party.gob"))
+	assert.NoError(t, err)
+	base, _ := encodeRecord(&journalRecord{Time: time.Now().Add(-time.Hour), Type: baseEvent,
+		Snapshot: snapshot})
+	filename := filepath.Join(d, "old.party.gob")
+	assert.NoError(t, appendToFile(journalFilename(filename), [][]byte{base}))
+
+	p, err := LoadAt(filename, time.Now())
+	assert.NoError(t, err)
+	checkFixtureContents(t, p)
+	assert.Len(t, p.JoinCode(), joinCodeLength)
+}
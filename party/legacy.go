@@ -0,0 +1,86 @@
+package party
+
+import (
+	"dnd/creature"
+	"encoding/gob"
+	"strings"
+)
+
+// Before version 2, creatures were identified by where they were in the encounter. Undo
+// histories and journals saved then still have actions that work that way, so they're kept
+// here, under the names gob saved them with, for as long as those files are around. Nothing new
+// makes them.
+func init() {
+	gob.RegisterName("*party.DamageCreatureAction", &legacyDamageCreatureAction{})
+	gob.RegisterName("dnd/party.DamageMultipleCreaturesAction", legacyDamageMultipleCreaturesAction{})
+	gob.RegisterName("*party.DeleteCreatureAction", &legacyDeleteCreatureAction{})
+}
+
+// ensureCreatureIDs gives an ID to any creature from before creatures had them
+func ensureCreatureIDs(creatures []*creature.Creature) {
+	for _, c := range creatures {
+		if c != nil && c.ID == "" {
+			c.ID = creature.NewID()
+		}
+	}
+}
+
+type legacyDamageCreatureAction struct {
+	ID, Amount int
+}
+
+func (a *legacyDamageCreatureAction) apply(p *party) {
+	p.EncounterCreatures[a.ID].DamageTaken += a.Amount
+}
+
+func (a *legacyDamageCreatureAction) undo(p *party) {
+	p.EncounterCreatures[a.ID].DamageTaken -= a.Amount
+}
+
+func (a *legacyDamageCreatureAction) describe(p *party) string {
+	return describeDamage(p.EncounterCreatures[a.ID], a.Amount)
+}
+
+type legacyDamageMultipleCreaturesAction []legacyDamageCreatureAction
+
+func (as legacyDamageMultipleCreaturesAction) apply(p *party) {
+	for _, a := range as {
+		a.apply(p)
+	}
+}
+
+func (as legacyDamageMultipleCreaturesAction) undo(p *party) {
+	for _, a := range as {
+		a.undo(p)
+	}
+}
+
+func (as legacyDamageMultipleCreaturesAction) describe(p *party) string {
+	descriptions := make([]string, len(as))
+	for i, a := range as {
+		descriptions[i] = a.describe(p)
+	}
+	return strings.Join(descriptions, "; ")
+}
+
+type legacyDeleteCreatureAction struct {
+	ID              int
+	DeletedCreature *creature.Creature
+}
+
+func (a *legacyDeleteCreatureAction) apply(p *party) {
+	a.DeletedCreature = p.EncounterCreatures[a.ID]
+	p.EncounterCreatures = append(p.EncounterCreatures[:a.ID], p.EncounterCreatures[a.ID+1:]...)
+}
+
+func (a *legacyDeleteCreatureAction) undo(p *party) {
+	p.EncounterCreatures = append(p.EncounterCreatures, nil)
+	copy(p.EncounterCreatures[a.ID+1:], p.EncounterCreatures[a.ID:])
+	p.EncounterCreatures[a.ID] = a.DeletedCreature
+	// It may have been saved before it had an ID
+	ensureCreatureIDs(p.EncounterCreatures[a.ID : a.ID+1])
+}
+
+func (a *legacyDeleteCreatureAction) describe(p *party) string {
+	return describeCreature(a.DeletedCreature) + " was removed from the encounter"
+}
@@ -0,0 +1,123 @@
+package party
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// LogEntry is a single line of the session log: either something that happened to the party
+// or a note the DM wrote down.
+type LogEntry struct {
+	Time      time.Time
+	Encounter int
+	Text      string
+	IsNote    bool
+}
+
+// LogInformation represents the session log of a game of D&D
+type LogInformation interface {
+	SessionLog() []LogEntry
+	AddNote(string)
+}
+
+// describedAction is an action that can explain itself for the session log.
+// describe is called with the party as it is after the action has been applied.
+type describedAction interface {
+	describe(*party) string
+}
+
+// LogEncounter is the entries of the log belonging to one encounter. Encounter 0 is everything
+// that happened before the first creature was added.
+type LogEncounter struct {
+	Number  int
+	Entries []LogEntry
+}
+
+// LogSession is the entries of the log from one day of play
+type LogSession struct {
+	Date       string
+	Encounters []*LogEncounter
+}
+
+const logDateFormat = "2006-01-02"
+
+// GroupLog splits the log up by session date, and then by encounter within that session
+func GroupLog(entries []LogEntry) []*LogSession {
+	sessions := make([]*LogSession, 0)
+	var session *LogSession
+	var encounter *LogEncounter
+	for _, e := range entries {
+		date := e.Time.Format(logDateFormat)
+		if session == nil || session.Date != date {
+			session = &LogSession{date, make([]*LogEncounter, 0)}
+			sessions = append(sessions, session)
+			encounter = nil
+		}
+		if encounter == nil || encounter.Number != e.Encounter {
+			encounter = &LogEncounter{e.Encounter, make([]LogEntry, 0)}
+			session.Encounters = append(session.Encounters, encounter)
+		}
+		encounter.Entries = append(encounter.Entries, e)
+	}
+	return sessions
+}
+
+// WriteLogMarkdown writes the session log as markdown, suitable for pasting into a wiki
+func WriteLogMarkdown(w io.Writer, title string, entries []LogEntry) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n", title)
+	for _, s := range GroupLog(entries) {
+		fmt.Fprintf(&b, "\n## Session %s\n", s.Date)
+		for _, e := range s.Encounters {
+			if e.Number == 0 {
+				b.WriteString("\n### Before combat\n\n")
+			} else {
+				fmt.Fprintf(&b, "\n### Encounter %d\n\n", e.Number)
+			}
+			for _, entry := range e.Entries {
+				if entry.IsNote {
+					fmt.Fprintf(&b, "- %s _%s_\n", entry.Time.Format("15:04"), entry.Text)
+				} else {
+					fmt.Fprintf(&b, "- %s %s\n", entry.Time.Format("15:04"), entry.Text)
+				}
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (p *party) log(text string, isNote bool) {
+	// When replaying the journal, entries get the time the event originally happened
+	t := p.replayTime
+	if t.IsZero() {
+		t = time.Now()
+	}
+	p.Log = append(p.Log, LogEntry{t, p.EncounterNumber, text, isNote})
+}
+
+// logAction records what an action did. prefix is for undo and redo.
+func (p *party) logAction(prefix string, action Action) {
+	d, ok := action.(describedAction)
+	if !ok {
+		return
+	}
+	p.log(prefix+d.describe(p), false)
+}
+
+// SessionLog returns every entry in the session log, oldest first
+func (p *party) SessionLog() []LogEntry {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]LogEntry(nil), p.Log...)
+}
+
+// AddNote adds a free text note from the DM to the session log
+func (p *party) AddNote(note string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.log(note, true)
+	p.record(journalRecord{Type: noteEvent, Text: note})
+}
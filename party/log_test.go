@@ -0,0 +1,86 @@
+package party
+
+import (
+	"bytes"
+	"dnd/creature"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActionsAreLogged(t *testing.T) {
+	p := testingParty()
+	c := creature.Create("goblin", "snaggletooth", testDiceRoll(7))
+	p.Apply(&AddCreatureAction{c})
+	p.Apply(&DamageCreatureAction{p.Creatures()[0].ID, 3})
+	p.Undo()
+	p.Redo()
+	p.AddNote("the goblin looks scared")
+	texts := make([]string, len(p.SessionLog()))
+	for i, e := range p.SessionLog() {
+		texts[i] = e.Text
+	}
+	assert.Equal(t, []string{
+		"snaggletooth (goblin) joined the encounter with 7 HP",
+		"snaggletooth (goblin) took 3 damage",
+		"Undid: snaggletooth (goblin) took 3 damage",
+		"Redid: snaggletooth (goblin) took 3 damage",
+		"the goblin looks scared",
+	}, texts)
+	assert.True(t, p.SessionLog()[4].IsNote)
+	assert.Equal(t, 1, p.SessionLog()[0].Encounter)
+}
+
+func TestEncounterNumbering(t *testing.T) {
+	p := testingParty()
+	p.Apply(&AddCreatureAction{creature.Create("orc", "", testDiceRoll(1))})
+	p.Apply(&AddCreatureAction{creature.Create("orc", "", testDiceRoll(1))})
+	assert.Equal(t, 1, p.EncounterNumber)
+	p.Apply(p.DeleteCreatureAction(p.Creatures()[1].ID))
+	p.Apply(p.DeleteCreatureAction(p.Creatures()[0].ID))
+	p.Apply(&AddCreatureAction{creature.Create("wolf", "", testDiceRoll(1))})
+	assert.Equal(t, 2, p.EncounterNumber)
+}
+
+func TestGroupLog(t *testing.T) {
+	day1 := time.Date(2026, 10, 1, 20, 0, 0, 0, time.Local)
+	day2 := day1.Add(24 * time.Hour)
+	entries := []LogEntry{
+		{day1, 0, "a", true},
+		{day1, 1, "b", false},
+		{day1, 1, "c", false},
+		{day2, 1, "d", false},
+		{day2, 2, "e", false},
+	}
+	sessions := GroupLog(entries)
+	assert.Equal(t, 2, len(sessions))
+	assert.Equal(t, "2026-10-01", sessions[0].Date)
+	assert.Equal(t, 2, len(sessions[0].Encounters))
+	assert.Equal(t, 2, len(sessions[0].Encounters[1].Entries))
+	assert.Equal(t, 2, len(sessions[1].Encounters))
+	assert.Equal(t, 2, sessions[1].Encounters[1].Number)
+}
+
+func TestWriteLogMarkdown(t *testing.T) {
+	day := time.Date(2026, 10, 1, 20, 15, 0, 0, time.Local)
+	entries := []LogEntry{
+		{day, 0, "we meet in a tavern", true},
+		{day, 1, "orc joined the encounter with 8 HP", false},
+	}
+	var b bytes.Buffer
+	err := WriteLogMarkdown(&b, "Heroes", entries)
+	assert.Nil(t, err)
+	assert.Equal(t, `# Heroes
+
+## Session 2026-10-01
+
+### Before combat
+
+- 20:15 _we meet in a tavern_
+
+### Encounter 1
+
+- 20:15 orc joined the encounter with 8 HP
+`, b.String())
+}
@@ -0,0 +1,142 @@
+package party
+
+import (
+	"bytes"
+	"dnd/dice"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// A migration upgrades a loaded party from one version of the file format to the next. It gets
+// the raw snapshot too, for when the data it needs is in a field the party struct no longer has:
+// decode raw into a struct describing the old layout to get at it.
+type migration struct {
+	description string
+	migrate     func(raw []byte, p *party) error
+}
+
+// migrations[v] upgrades a party from version v to version v+1. Add new ones on the end, and
+// bump currentVersion to match. Each time the format changes, add a file saved in the old format
+// to testdata.
+var migrations = []migration{
+	{"save the undo history", func(raw []byte, p *party) error {
+		// Nothing to do: files from before this just have no history, so keep the empty buffer
+		return nil
+	}},
+	{"give every creature an ID", func(raw []byte, p *party) error {
+		// Actions in the history that identify creatures by position are decoded as legacy
+		// actions, which still work, so it's just the creatures themselves
+		ensureCreatureIDs(p.EncounterCreatures)
+		return nil
+	}},
+	{"give the party a join code", func(raw []byte, p *party) error {
+		// It used to be made the first time a page showed it
+		if p.PlayerJoinCode == "" {
+			p.PlayerJoinCode = generateJoinCode()
+		}
+		return nil
+	}},
+	{"keep each roll's details together", func(raw []byte, p *party) error {
+		var old legacyRolls
+		err := gob.NewDecoder(bytes.NewReader(raw)).Decode(&old)
+		if err != nil {
+			return err
+		}
+		// The lists kept alongside the rolls were added over time, so older rolls might not
+		// have entries in them
+		rolls := make([]rollRecord, len(old.PreviousRolls))
+		for i, roll := range old.PreviousRolls {
+			rolls[i].Result = roll
+			if i < len(old.RollVisibilities) {
+				rolls[i].Visibility = old.RollVisibilities[i]
+			}
+			if i < len(old.RollPlayers) {
+				rolls[i].Player = old.RollPlayers[i]
+			}
+			if i < len(old.RollChatAuthors) {
+				rolls[i].ChatAuthor = old.RollChatAuthors[i]
+			}
+			if i < len(old.RollLabels) {
+				rolls[i].Label = old.RollLabels[i]
+			}
+		}
+		p.RollHistory = rolls
+		return nil
+	}},
+}
+
+// legacyRolls is how parties kept their rolls up to version 3, in a list for each detail
+type legacyRolls struct {
+	PreviousRolls    []dice.RollResult
+	RollVisibilities []RollVisibility
+	RollPlayers      []string
+	RollChatAuthors  []string
+	RollLabels       []string
+}
+
+// backupFilename is where a party file is copied before being migrated from a version
+func backupFilename(filename string, version int) string {
+	return fmt.Sprintf("%s.v%d.bak", filename, version)
+}
+
+// backUp copies a file before it gets migrated. An existing backup is never overwritten, as that
+// would be the oldest copy of the data.
+func backUp(filename string, version int) error {
+	backup := backupFilename(filename, version)
+	if _, err := os.Stat(backup); err == nil {
+		return nil
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomically(backup, data, 0640)
+}
+
+// migrate brings a party loaded from an older file up to the current version, backing up the
+// files it was loaded from first.
+func (p *party) migrate(filename string, raw []byte) error {
+	if p.Version >= currentVersion {
+		return p.upgrade(raw)
+	}
+	err := backUp(filename, p.Version)
+	if err != nil {
+		return fmt.Errorf("error backing up party before migrating - %v", err)
+	}
+	journal := journalFilename(filename)
+	if _, err := os.Stat(journal); err == nil {
+		err = backUp(journal, p.Version)
+		if err != nil {
+			return fmt.Errorf("error backing up journal before migrating - %v", err)
+		}
+	}
+	return p.upgrade(raw)
+}
+
+// upgrade runs the migrations that bring a party from its version up to the current one. Stores
+// back up what the party was loaded from before calling it.
+func (p *party) upgrade(raw []byte) error {
+	if p.Version > currentVersion {
+		return fmt.Errorf("party is version %d, but only up to %d is understood - is this an "+
+			"old version of the program?", p.Version, currentVersion)
+	}
+	if p.Version == currentVersion {
+		return nil
+	}
+	for p.Version < currentVersion {
+		m := migrations[p.Version]
+		log.Printf("Migrating party '%s' from version %d to %d: %s", p.name(), p.Version,
+			p.Version+1, m.description)
+		err := m.migrate(raw, p)
+		if err != nil {
+			return fmt.Errorf("error migrating from version %d - %v", p.Version, err)
+		}
+		p.Version++
+	}
+	// The old journal was written in the old format, so start again from a migrated snapshot
+	p.restartJournal = true
+	return nil
+}
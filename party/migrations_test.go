@@ -0,0 +1,169 @@
+package party
+
+import (
+	"bytes"
+	"dnd/dice"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// loadFixture copies a party file from testdata somewhere it can be migrated, and loads it
+func loadFixture(t *testing.T, directory, name string) Party {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("Couldn't read fixture - %v", err)
+	}
+	filename := filepath.Join(directory, name)
+	err = ioutil.WriteFile(filename, data, 0640)
+	if err != nil {
+		t.Fatalf("Couldn't copy fixture - %v", err)
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatalf("Couldn't open fixture - %v", err)
+	}
+	defer f.Close()
+	p, err := Load(f)
+	if err != nil {
+		t.Fatalf("Couldn't load fixture - %v", err)
+	}
+	return p
+}
+
+func TestEveryVersionHasAMigration(t *testing.T) {
+	assert.Equal(t, currentVersion, len(migrations))
+	for v := 0; v < currentVersion; v++ {
+		_, err := os.Stat(filepath.Join("testdata", fmt.Sprintf("v%d.party.gob", v)))
+		assert.NoError(t, err, "no fixture for version %d", v)
+	}
+}
+
+func checkFixtureContents(t *testing.T, p Party) {
+	assert.Equal(t, currentVersion, p.(*party).Version)
+	assert.Equal(t, "alice", p.(*party).Players[0].Name)
+	assert.Equal(t, 2, len(p.Creatures()))
+	assert.Equal(t, "grom", p.Creatures()[0].Name)
+	assert.Equal(t, 3, p.Creatures()[0].DamageTaken)
+	assert.Equal(t, 1, len(p.Rolls()))
+	assert.Equal(t, "d20 + 5", p.CustomRoll())
+}
+
+func TestMigrateVersion0(t *testing.T) {
+	d := testingDirectory(t)
+	defer os.RemoveAll(d)
+	p := loadFixture(t, d, "v0.party.gob")
+	checkFixtureContents(t, p)
+	assert.False(t, p.CanUndo())
+
+	original, _ := ioutil.ReadFile(filepath.Join("testdata", "v0.party.gob"))
+	backup, err := ioutil.ReadFile(backupFilename(filepath.Join(d, "v0.party.gob"), 0))
+	assert.NoError(t, err)
+	assert.Equal(t, original, backup)
+
+	// The migrated party is saved in the current format
+	reloaded := saveAndLoad(t, p)
+	checkFixtureContents(t, reloaded)
+}
+
+func TestMigrateVersion1(t *testing.T) {
+	d := testingDirectory(t)
+	defer os.RemoveAll(d)
+	p := loadFixture(t, d, "v1.party.gob")
+	checkFixtureContents(t, p)
+	for _, c := range p.Creatures() {
+		assert.NotEmpty(t, c.ID)
+	}
+	_, err := os.Stat(backupFilename(filepath.Join(d, "v1.party.gob"), 1))
+	assert.NoError(t, err)
+
+	// The history from before creatures had IDs can still be undone and redone
+	assert.True(t, p.CanUndo())
+	assert.NoError(t, p.Undo())
+	assert.Equal(t, 0, p.Creatures()[0].DamageTaken)
+	assert.NoError(t, p.Redo())
+	reloaded := saveAndLoad(t, p)
+	checkFixtureContents(t, reloaded)
+	assert.Equal(t, p.Creatures()[0].ID, reloaded.Creatures()[0].ID)
+	assert.NoError(t, reloaded.Undo())
+	assert.Equal(t, 0, reloaded.Creatures()[0].DamageTaken)
+}
+
+func TestMigrateVersion2(t *testing.T) {
+	d := testingDirectory(t)
+	defer os.RemoveAll(d)
+	p := loadFixture(t, d, "v2.party.gob")
+	checkFixtureContents(t, p)
+	code := p.JoinCode()
+	assert.Len(t, code, joinCodeLength)
+	_, err := os.Stat(backupFilename(filepath.Join(d, "v2.party.gob"), 2))
+	assert.NoError(t, err)
+	reloaded := saveAndLoad(t, p)
+	assert.Equal(t, code, reloaded.JoinCode())
+}
+
+func TestMigrateVersion3(t *testing.T) {
+	d := testingDirectory(t)
+	defer os.RemoveAll(d)
+	p := loadFixture(t, d, "v3.party.gob")
+	checkFixtureContents(t, p)
+	_, err := os.Stat(backupFilename(filepath.Join(d, "v3.party.gob"), 3))
+	assert.NoError(t, err)
+
+	// Lists added after some rolls were made are shorter than the rolls
+	old := struct {
+		Version          int
+		PartyName        string
+		PreviousRolls    []dice.RollResult
+		RollVisibilities []RollVisibility
+		RollPlayers      []string
+		RollLabels       []string
+	}{3, "ragged", []dice.RollResult{dice.RollResult{}, dice.RollResult{Sum: 7}},
+		[]RollVisibility{PublicRoll}, []string{"", "alice"}, []string{"", "stealth"}}
+	var b bytes.Buffer
+	assert.NoError(t, gob.NewEncoder(&b).Encode(old))
+	ioutil.WriteFile(filepath.Join(d, "ragged.party.gob"), b.Bytes(), 0640)
+	f, _ := os.Open(filepath.Join(d, "ragged.party.gob"))
+	defer f.Close()
+	ragged, err := Load(f)
+	assert.NoError(t, err)
+	rolls := ragged.RecordedRolls()
+	assert.Equal(t, 2, len(rolls))
+	assert.Equal(t, &RecordedRoll{&dice.RollResult{Sum: 7}, 1, PublicRoll, "alice", "", "stealth"},
+		rolls[0])
+	assert.Equal(t, &RecordedRoll{&dice.RollResult{}, 0, PublicRoll, "", "", ""}, rolls[1])
+}
+
+func TestLoadCurrentVersion(t *testing.T) {
+	d := testingDirectory(t)
+	defer os.RemoveAll(d)
+	p := loadFixture(t, d, "v4.party.gob")
+	checkFixtureContents(t, p)
+	assert.True(t, p.CanUndo())
+	assert.NoError(t, p.Undo())
+	assert.Equal(t, 0, p.Creatures()[0].DamageTaken)
+	_, err := os.Stat(backupFilename(filepath.Join(d, "v4.party.gob"), 4))
+	assert.True(t, os.IsNotExist(err))
+	// The join code was saved, so it's the same every time the party is loaded
+	again := loadFixture(t, testingDirectory(t), "v4.party.gob")
+	assert.Equal(t, p.JoinCode(), again.JoinCode())
+}
+
+func TestRefuseNewerVersion(t *testing.T) {
+	d := testingDirectory(t)
+	defer os.RemoveAll(d)
+	p := New(d, "future").(*party)
+	p.Version = currentVersion + 1
+	var b bytes.Buffer
+	gob.NewEncoder(&b).Encode(p)
+	ioutil.WriteFile(p.Filename, b.Bytes(), 0640)
+	f, _ := os.Open(p.Filename)
+	defer f.Close()
+	_, err := Load(f)
+	assert.Error(t, err)
+}
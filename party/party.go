@@ -1,15 +1,19 @@
 package party
 
 import (
+	"bytes"
 	"dnd/creature"
 	"dnd/dice"
 	"dnd/undobuffer"
 	"encoding/gob"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 )
 
 // Encounter creature represents the information about a creature for the purpose of one
@@ -20,17 +24,25 @@ type EncounterCreature struct {
 	Initiative     int
 }
 
+// currentVersion is the version of the party format written by Save. Files written by older
+// versions are upgraded by the migrations when they are loaded.
+const currentVersion = 4
+
 // party is a structure suitable for storing as a gob that fulfils the requirements of the
 // interface. It is private so that I can use public methods and get auto-gob persistence (lazy!)
 type party struct {
+	Version             int
 	Filename, PartyName string
-	actions             *undobuffer.Buffer
+	Actions             *undobuffer.Buffer
 	Players             []*Player
 
 	// For dice server
-	PreviousRolls  []dice.RollResult
+	RollHistory    []rollRecord
 	LastCustomRoll string
 
+	// For players joining from their phones
+	PlayerJoinCode string
+
 	// For encounter server
 	EncounterCreatures []*creature.Creature
 
@@ -38,37 +50,51 @@ type party struct {
 	PlayerHasInitiatives      []bool
 	PlayerInitiativeRolls     []int
 	CurrentEncounterCreatures []*EncounterCreature
-}
+	Turn                      int
 
-// Save the party to its Filename'd .gob file
-func (p *party) Save() error {
-	file, err := os.Create(p.Filename)
-	if err != nil {
-		return err
-	}
-	defer func() {
-		err := file.Close()
-		if err != nil {
-			log.Printf("Error closing party file - %v", err)
-		}
-	}()
-	encoder := gob.NewEncoder(file)
-	return encoder.Encode(p)
+	// For the session log
+	Log             []LogEntry
+	EncounterNumber int
+
+	// For the journal. JournalSeq is the sequence number of the last event applied to the party.
+	JournalSeq     int
+	snapshotSeq    int
+	pending        [][]byte
+	replaying      bool
+	replayTime     time.Time
+	restartJournal bool
+
+	// The store the party saves itself to. Without one it saves straight to Filename.
+	store Store
+	// Whether the store has taken a backup since the party was loaded
+	backedUp bool
+	// Channels to tell about changes to the party
+	subscribers map[chan Change]bool
+
+	// Requests for the party are served on their own goroutines, so every public method holds
+	// mutex. Methods returning part of the party return copies, so that they can't change
+	// underneath the caller.
+	mutex sync.Mutex
 }
 
 // Party represents a party in a game of D&D
 type Party interface {
 	Name() string
 	Save() error
+	Export(w io.Writer) error
+	Duplicate(name string) Party
 	Apply(action Action) error
 	Undo() error
 	CanUndo() bool
 	Redo() error
 	CanRedo() bool
+	Subscribe() (<-chan Change, func())
 
 	RollInformation
 	EncounterInformation
 	InitiativeInformation
+	LogInformation
+	PlayerInformation
 }
 
 // RollInformation represents information about the rolls in a game of D&D
@@ -76,13 +102,18 @@ type RollInformation interface {
 	CustomRoll() string
 	SetCustomRoll(string)
 	Rolls() []*dice.RollResult
-	AddRoll(dice.RollResult)
+	RecordedRolls() []*RecordedRoll
+	PublicRolls() []*RecordedRoll
+	AddRoll(dice.RollResult, RollVisibility)
+	AddChatRoll(author, label string, roll dice.RollResult)
+	RevealRollAction(ID int) (*RevealRollAction, error)
 }
 
 // EncounterInformation represents information about the encounters in a game of D&D
 type EncounterInformation interface {
 	Creatures() []*creature.Creature
-	DeleteCreatureAction(ID int) *DeleteCreatureAction
+	Creature(ID string) *creature.Creature
+	DeleteCreatureAction(ID string) *DeleteCreatureAction
 }
 
 // InitiativeInformation is the information about a creature's initiative
@@ -95,38 +126,120 @@ type CreatureInitiative struct {
 // InitiativeInformation represents information about the initiative in the current combat
 type InitiativeInformation interface {
 	PlayerInitiatives() []*CreatureInitiative
+	TurnOrder() []*CreatureInitiative
+	CurrentTurn() int
+	InitiativeAction(InitiativeChange) (*InitiativeAction, error)
 }
 
 // New creates a new party to be saved in the given directory
 func New(directory string, name string) Party {
 	return &party{
+		currentVersion,
 		filepath.Join(directory, name+".party.gob"),
 		name,
 		undobuffer.NewBuffer(64),
 		make([]*Player, 0),
-		make([]dice.RollResult, 0),
+		make([]rollRecord, 0),
 		"",
+		generateJoinCode(),
 		make([]*creature.Creature, 0),
 		make([]bool, 0),
 		make([]int, 0),
-		make([]*EncounterCreature, 0)}
+		make([]*EncounterCreature, 0),
+		0,
+		make([]LogEntry, 0),
+		0,
+		0,
+		0,
+		nil,
+		false,
+		time.Time{},
+		// A new party never carries on from a journal some other party left behind
+		true,
+		nil,
+		false,
+		nil,
+		sync.Mutex{}}
 }
 
-// Load party from a gob file
+// Duplicate starts a new party with the same players, for using a party as a template. None of
+// the history comes with them.
+func (p *party) Duplicate(name string) Party {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	d := New("", name).(*party)
+	for _, player := range p.Players {
+		d.Players = append(d.Players, &Player{player.Name, false})
+	}
+	d.alignInitiatives()
+	return d
+}
+
+// Save the party to the store it belongs to, or to its file if it doesn't belong to one
+func (p *party) Save() error {
+	p.mutex.Lock()
+	store := p.store
+	if store == nil {
+		defer p.mutex.Unlock()
+		return p.saveFile()
+	}
+	// Stores take the lock themselves
+	p.mutex.Unlock()
+	return store.Save(p)
+}
+
+// Load party from a gob file, replaying anything in its journal that the file is missing, and
+// migrating it if it was saved by an older version.
 func Load(file *os.File) (Party, error) {
-	decoder := gob.NewDecoder(file)
+	raw, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	p, err := decodeSnapshot(raw)
+	if err != nil {
+		return nil, err
+	}
+	// Where the file actually is wins over where it was saved, in case it has been moved
+	p.Filename = file.Name()
+	p.snapshotSeq = p.JournalSeq
+	p.restartJournal = false
+	err = p.migrate(file.Name(), raw)
+	if err != nil {
+		return nil, err
+	}
+	// The migrations only know about the snapshot, so replay the journal onto the migrated
+	// party. Its events are the same in every version.
+	err = p.replayJournal(file.Name())
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// decodeSnapshot decodes a snapshot of a party, which might be from an older version
+func decodeSnapshot(raw []byte) (*party, error) {
 	// Create a party this way in order to ensure that the undobuffer gets created if necessary.
 	// A bit ugly on the GC.
-	party := New("", "")
-	err := decoder.Decode(party)
+	p := New("", "").(*party)
+	// Fields missing from the file keep what New gave them, so clear those that older versions
+	// might not have saved
+	p.Version = 0
+	p.PlayerJoinCode = ""
+	err := gob.NewDecoder(bytes.NewReader(raw)).Decode(p)
 	if err != nil {
 		return nil, err
 	}
-	return party, nil
+	return p, nil
 }
 
 // Name returns the party's name
 func (p *party) Name() string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.name()
+}
+
+func (p *party) name() string {
 	if p.PartyName != "" {
 		return p.PartyName
 	}
@@ -136,19 +249,35 @@ func (p *party) Name() string {
 	return nameGuess
 }
 
-// Apply an action to the party, adding it to the undo buffer. Returns an error if action is nil
+// Apply an action to the party, adding it to the undo buffer. Returns an error if action is nil,
+// or doesn't make sense for the party as it is now.
 func (p *party) Apply(action Action) error {
 	if action == nil {
 		return errors.New("can't apply a nil action")
 	}
-	p.actions.Push(action)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if v, ok := action.(validator); ok {
+		if err := v.validate(p); err != nil {
+			return err
+		}
+	}
+	// Adding a creature to an empty encounter is what starts a new fight
+	if _, ok := action.(*AddCreatureAction); ok && len(p.EncounterCreatures) == 0 {
+		p.EncounterNumber++
+	}
+	p.Actions.Push(action)
 	action.apply(p)
+	p.logAction("", action)
+	p.record(journalRecord{Type: applyEvent, Action: action})
 	return nil
 }
 
 // Undo the last action in the buffer
 func (p *party) Undo() error {
-	raw, err := p.actions.Pop()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	raw, err := p.Actions.Pop()
 	if err != nil {
 		return err
 	}
@@ -156,16 +285,23 @@ func (p *party) Undo() error {
 	if !ok {
 		return fmt.Errorf("undobuffer contains '%v', not a Reversible Action", raw)
 	}
+	// Described before undoing, so the description sees the state the action left behind
+	p.logAction("Undid: ", action)
 	action.undo(p)
+	p.record(journalRecord{Type: undoEvent})
 	return nil
 }
 
 func (p *party) CanUndo() bool {
-	return p.actions.CanPop()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.Actions.CanPop()
 }
 
 func (p *party) Redo() error {
-	raw, err := p.actions.Unpop()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	raw, err := p.Actions.Unpop()
 	if err != nil {
 		return fmt.Errorf("error redoing: %v", err)
 	}
@@ -174,55 +310,116 @@ func (p *party) Redo() error {
 		return fmt.Errorf("undobuffer contains '%v', not a Reversible Action", raw)
 	}
 	action.apply(p)
+	p.logAction("Redid: ", action)
+	p.record(journalRecord{Type: redoEvent})
 	return nil
 }
 
 func (p *party) CanRedo() bool {
-	return p.actions.CanUnpop()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.Actions.CanUnpop()
 }
 
 // CustomRoll is the last thing the user typed in the custom roll box
 func (p *party) CustomRoll() string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
 	return p.LastCustomRoll
 }
 
 // SetCustomRoll sets the last thing the user typed in the custom roll box
 func (p *party) SetCustomRoll(roll string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
 	p.LastCustomRoll = roll
+	p.record(journalRecord{Type: customRollEvent, Text: roll})
 }
 
 // Rolls returns the results of the user's rolls, most recent first
 func (p *party) Rolls() []*dice.RollResult {
-	r := make([]*dice.RollResult, len(p.PreviousRolls))
-	for i := 0; i < len(p.PreviousRolls); i++ {
-		r[len(p.PreviousRolls)-1-i] = &p.PreviousRolls[i]
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	r := make([]*dice.RollResult, len(p.RollHistory))
+	for i, record := range p.RollHistory {
+		roll := record.Result
+		r[len(p.RollHistory)-1-i] = &roll
 	}
 	return r
 }
 
 // AddRoll adds the result of rolling dice to the party
-func (p *party) AddRoll(roll dice.RollResult) {
-	p.PreviousRolls = append(p.PreviousRolls, roll)
+func (p *party) AddRoll(roll dice.RollResult, visibility RollVisibility) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.addRoll(roll, visibility, rollMaker{})
+}
+
+// AddChatRoll adds a roll someone made in a chat, and what it was for. Chat names can't be
+// trusted to be who they say, so it's never a player's roll, even if the names match. Rolls from
+// the chat are always public.
+func (p *party) AddChatRoll(author, label string, roll dice.RollResult) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.addRoll(roll, PublicRoll, rollMaker{chatAuthor: author, label: label})
+}
+
+// rollMaker is who made a roll: a player, someone in a chat, or the DM if neither is set. label
+// is what it was for.
+type rollMaker struct {
+	player, chatAuthor, label string
+}
+
+func (p *party) addRoll(roll dice.RollResult, visibility RollVisibility, by rollMaker) {
+	p.RollHistory = append(p.RollHistory,
+		rollRecord{roll, visibility, by.player, by.chatAuthor, by.label})
+	p.record(journalRecord{Type: rollEvent, Roll: roll, Visibility: visibility, Text: by.player,
+		ChatAuthor: by.chatAuthor, Label: by.label})
 }
 
 // Creatures returns the creatures in the party's encounters
 func (p *party) Creatures() []*creature.Creature {
-	return p.EncounterCreatures
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	r := make([]*creature.Creature, len(p.EncounterCreatures))
+	for i, c := range p.EncounterCreatures {
+		copied := *c
+		r[i] = &copied
+	}
+	return r
+}
+
+// Creature returns a copy of the creature with an ID, or nil if it isn't in the encounter
+func (p *party) Creature(ID string) *creature.Creature {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	i := p.creatureIndex(ID)
+	if i == -1 {
+		return nil
+	}
+	copied := *p.EncounterCreatures[i]
+	return &copied
 }
 
 // DeleteCreatureAction creatures a delete creature action for a creature
-func (p *party) DeleteCreatureAction(ID int) *DeleteCreatureAction {
+func (p *party) DeleteCreatureAction(ID string) *DeleteCreatureAction {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
 	return newDeleteCreatureAction(p, ID)
 }
 
 // PlayerInitiatives gets the information about the players initiatives
 func (p *party) PlayerInitiatives() []*CreatureInitiative {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
 	r := make([]*CreatureInitiative, len(p.Players))
 	for i, player := range p.Players {
-		r[i] = &CreatureInitiative{
-			player.Name,
-			p.PlayerHasInitiatives[i],
-			p.PlayerInitiativeRolls[i]}
+		r[i] = &CreatureInitiative{player.Name, false, 0}
+		// Players from before initiatives were kept for everyone might not have entries
+		if i < len(p.PlayerHasInitiatives) && i < len(p.PlayerInitiativeRolls) {
+			r[i].HasInitiative = p.PlayerHasInitiatives[i]
+			r[i].Initiative = p.PlayerInitiativeRolls[i]
+		}
 	}
 	return r
 }
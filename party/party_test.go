@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"os"
 	"strconv"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -15,7 +16,7 @@ import (
 
 func testingParty() *party {
 	var p party
-	p.actions = undobuffer.NewBuffer(4)
+	p.Actions = undobuffer.NewBuffer(4)
 	return &p
 }
 
@@ -40,7 +41,7 @@ func TestGob(t *testing.T) {
 	var encodeParty party
 	encodeParty.Filename = "colin"
 	encodeParty.PartyName = "is"
-	err = e.Encode(encodeParty)
+	err = e.Encode(&encodeParty)
 	if err != nil {
 		t.Fatalf("Error encoding party - %v", err)
 	}
@@ -82,9 +83,9 @@ func TestRolls(t *testing.T) {
 	r1 := testDiceRoll(1).Simulate()
 	r2 := testDiceRoll(2).Simulate()
 	r3 := testDiceRoll(3).Simulate()
-	p.AddRoll(r1)
-	p.AddRoll(r2)
-	p.AddRoll(r3)
+	p.AddRoll(r1, PublicRoll)
+	p.AddRoll(r2, PublicRoll)
+	p.AddRoll(r3, PublicRoll)
 	e := []*dice.RollResult{&r3, &r2, &r1}
 	assert.Equal(t, e, p.Rolls())
 }
@@ -94,3 +95,91 @@ func TestCustomRoll(t *testing.T) {
 	p.SetCustomRoll("colin is great!")
 	assert.Equal(t, "colin is great!", p.CustomRoll())
 }
+
+func saveAndLoad(t *testing.T, p Party) Party {
+	err := p.Save()
+	if err != nil {
+		t.Fatalf("Error saving party - %v", err)
+	}
+	f, err := os.Open(p.(*party).Filename)
+	if err != nil {
+		t.Fatalf("Error opening saved party - %v", err)
+	}
+	defer f.Close()
+	loaded, err := Load(f)
+	if err != nil {
+		t.Fatalf("Error loading party - %v", err)
+	}
+	return loaded
+}
+
+func TestUndoHistorySurvivesReload(t *testing.T) {
+	d, err := ioutil.TempDir("", "encounters")
+	if err != nil {
+		t.Fatalf("Couldn't create temporary directory - %v", err)
+	}
+	defer os.RemoveAll(d)
+	p := New(d, "undo")
+	p.Apply(&AddCreatureAction{creature.Create("orc", "grom", testDiceRoll(10))})
+	p.Apply(&DamageCreatureAction{p.Creatures()[0].ID, 4})
+	p.Apply(&AddCreatureAction{creature.Create("orc", "gash", testDiceRoll(10))})
+	p.Apply(p.DeleteCreatureAction(p.Creatures()[1].ID))
+	p.Undo()
+
+	loaded := saveAndLoad(t, p)
+	assert.True(t, loaded.CanUndo())
+	assert.True(t, loaded.CanRedo())
+	assert.NoError(t, loaded.Redo())
+	assert.Equal(t, 1, len(loaded.Creatures()))
+	assert.NoError(t, loaded.Undo())
+	assert.NoError(t, loaded.Undo())
+	assert.NoError(t, loaded.Undo())
+	assert.Equal(t, 0, loaded.Creatures()[0].DamageTaken)
+	assert.NoError(t, loaded.Redo())
+	assert.NoError(t, loaded.Redo())
+	assert.Equal(t, 4, loaded.Creatures()[0].DamageTaken)
+	assert.Equal(t, "gash", loaded.Creatures()[1].Name)
+}
+
+func TestDuplicate(t *testing.T) {
+	p := New("", "heroes")
+	p.Apply(&AddPlayerAction{"Anya"})
+	p.Apply(&AddCreatureAction{creature.Create("orc", "grom", testDiceRoll(10))})
+	p.AddNote("The orc looks hungry")
+
+	d := p.Duplicate("heroes again")
+	assert.Equal(t, "heroes again", d.Name())
+	assert.Equal(t, 1, len(d.PlayerInitiatives()))
+	assert.Equal(t, "Anya", d.PlayerInitiatives()[0].Name)
+	assert.Equal(t, 0, len(d.Creatures()))
+	assert.Equal(t, 0, len(d.SessionLog()))
+	assert.False(t, d.CanUndo())
+}
+
+// TestConcurrentUse is meant to be run with -race
+func TestConcurrentUse(t *testing.T) {
+	s := NewMemoryStore()
+	p := New("", "heroes")
+	assert.NoError(t, s.Save(p))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				p.Apply(&AddCreatureAction{creature.Create("orc", "grom", testDiceRoll(10))})
+				p.Apply(&DamageCreatureAction{p.Creatures()[0].ID, 1})
+				for _, c := range p.Creatures() {
+					c.DamageTaken = 100
+				}
+				p.AddNote("note")
+				p.CanUndo()
+				assert.NoError(t, p.Save())
+				p.SessionLog()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 8*20, len(p.Creatures()))
+	assert.Equal(t, 8*20, p.Creatures()[0].DamageTaken)
+}
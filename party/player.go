@@ -1,5 +1,163 @@
 package party
 
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"dnd/dice"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"log"
+)
+
+func init() {
+	gob.Register(&LockPlayerAction{})
+}
+
+// Player is one of the players in the party. Locked players can't roll from their phones.
 type Player struct {
-	Name string
+	Name   string
+	Locked bool
+}
+
+// PlayerInformation is what's needed to let players join the party from their phones and roll
+// their own dice
+type PlayerInformation interface {
+	JoinCode() string
+	NewJoinCode()
+	PlayerToken(name string) string
+	CheckPlayerToken(name, token string) bool
+	PlayerLocked(name string) bool
+	LockPlayerAction(name string, locked bool) (*LockPlayerAction, error)
+	AddPlayerRoll(name string, roll dice.RollResult) error
+}
+
+// joinCodeAlphabet leaves out letters and numbers that are easily mixed up
+const joinCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+const joinCodeLength = 6
+
+func generateJoinCode() string {
+	b := make([]byte, joinCodeLength)
+	_, err := rand.Read(b)
+	if err != nil {
+		// The system's random number generator is broken, and nothing's going to work anyway
+		log.Panicf("Error generating join code - %v", err)
+	}
+	for i := range b {
+		b[i] = joinCodeAlphabet[int(b[i])%len(joinCodeAlphabet)]
+	}
+	return string(b)
+}
+
+func (p *party) setJoinCode(code string) {
+	p.PlayerJoinCode = code
+	p.record(journalRecord{Type: joinCodeEvent, Text: code})
+}
+
+// JoinCode is the code players type in to join the party from their phones
+func (p *party) JoinCode() string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.PlayerJoinCode
+}
+
+// NewJoinCode replaces the join code, which signs everybody out
+func (p *party) NewJoinCode() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.setJoinCode(generateJoinCode())
+}
+
+func (p *party) playerToken(name string) string {
+	mac := hmac.New(sha256.New, []byte(p.PlayerJoinCode))
+	mac.Write([]byte(name))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// PlayerToken is what a player's phone keeps to show who they are. Tokens are made from the join
+// code, so a new join code means new tokens.
+func (p *party) PlayerToken(name string) string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.playerToken(name)
+}
+
+// CheckPlayerToken is whether a token belongs to a player in the party
+func (p *party) CheckPlayerToken(name, token string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.player(name) == nil {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(p.playerToken(name)))
+}
+
+func (p *party) player(name string) *Player {
+	for _, player := range p.Players {
+		if player.Name == name {
+			return player
+		}
+	}
+	return nil
+}
+
+// PlayerLocked is whether a player has been stopped from rolling
+func (p *party) PlayerLocked(name string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	player := p.player(name)
+	return player != nil && player.Locked
+}
+
+// AddPlayerRoll adds a roll a player made on their phone. Players' rolls are always public.
+func (p *party) AddPlayerRoll(name string, roll dice.RollResult) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	player := p.player(name)
+	if player == nil {
+		return fmt.Errorf("there's no player called '%s'", name)
+	}
+	if player.Locked {
+		return fmt.Errorf("%s isn't allowed to roll at the moment", name)
+	}
+	p.addRoll(roll, PublicRoll, rollMaker{player: name})
+	return nil
+}
+
+// LockPlayerAction stops a player rolling from their phone, or lets them again
+type LockPlayerAction struct {
+	Name           string
+	Locked, Before bool
+}
+
+// LockPlayerAction creates an action locking or unlocking a player
+func (p *party) LockPlayerAction(name string, locked bool) (*LockPlayerAction, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.player(name) == nil {
+		return nil, fmt.Errorf("there's no player called '%s'", name)
+	}
+	return &LockPlayerAction{Name: name, Locked: locked}, nil
+}
+
+func (a *LockPlayerAction) apply(p *party) {
+	if player := p.player(a.Name); player != nil {
+		a.Before = player.Locked
+		player.Locked = a.Locked
+	}
+}
+
+func (a *LockPlayerAction) undo(p *party) {
+	if player := p.player(a.Name); player != nil {
+		player.Locked = a.Before
+	}
+}
+
+func (a *LockPlayerAction) describe(p *party) string {
+	if a.Locked {
+		return a.Name + " was stopped from rolling"
+	}
+	return a.Name + " was allowed to roll again"
 }
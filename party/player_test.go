@@ -0,0 +1,58 @@
+package party
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlayerTokens(t *testing.T) {
+	p := New("", "heroes")
+	code := p.JoinCode()
+	assert.Equal(t, joinCodeLength, len(code))
+	assert.Equal(t, code, p.JoinCode())
+	// The code is made with the party, so looking at it changes nothing
+	assert.Empty(t, p.(*party).pending)
+	p.Apply(&AddPlayerAction{"Anya"})
+
+	token := p.PlayerToken("Anya")
+	assert.True(t, p.CheckPlayerToken("Anya", token))
+	assert.False(t, p.CheckPlayerToken("Anya", strings.ToUpper(token)))
+	assert.False(t, p.CheckPlayerToken("Bram", p.PlayerToken("Bram")))
+
+	// A new code signs everybody out
+	p.NewJoinCode()
+	assert.NotEqual(t, code, p.JoinCode())
+	assert.False(t, p.CheckPlayerToken("Anya", token))
+}
+
+func TestPlayerRolls(t *testing.T) {
+	d := testingDirectory(t)
+	defer os.RemoveAll(d)
+	p := New(d, "heroes")
+	p.Apply(&AddPlayerAction{"Anya"})
+	code := p.JoinCode()
+	assert.NoError(t, p.AddPlayerRoll("Anya", testDiceRoll(7).Simulate()))
+	assert.Error(t, p.AddPlayerRoll("Bram", testDiceRoll(7).Simulate()))
+	p.AddRoll(testDiceRoll(3).Simulate(), PublicRoll)
+	assert.Equal(t, "", p.RecordedRolls()[0].Player)
+	assert.Equal(t, "Anya", p.RecordedRolls()[1].Player)
+
+	a, err := p.LockPlayerAction("Anya", true)
+	assert.NoError(t, err)
+	assert.NoError(t, p.Apply(a))
+	assert.True(t, p.PlayerLocked("Anya"))
+	assert.Error(t, p.AddPlayerRoll("Anya", testDiceRoll(7).Simulate()))
+	_, err = p.LockPlayerAction("Bram", true)
+	assert.Error(t, err)
+
+	// The join code, who rolled what and who's locked all survive a reload
+	loaded := saveAndLoad(t, p)
+	assert.Equal(t, code, loaded.JoinCode())
+	assert.Equal(t, "Anya", loaded.RecordedRolls()[1].Player)
+	assert.True(t, loaded.PlayerLocked("Anya"))
+	assert.NoError(t, loaded.Undo())
+	assert.False(t, loaded.PlayerLocked("Anya"))
+}
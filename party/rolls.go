@@ -0,0 +1,137 @@
+package party
+
+import (
+	"dnd/dice"
+	"encoding/gob"
+	"fmt"
+)
+
+func init() {
+	gob.Register(&RevealRollAction{})
+}
+
+// RollVisibility is who gets to see a roll
+type RollVisibility int
+
+const (
+	// PublicRoll is seen by everyone. It's the zero value, so rolls from before there were
+	// hidden rolls are public.
+	PublicRoll RollVisibility = iota
+	// DMOnlyRoll is only seen by the DM
+	DMOnlyRoll
+	// RevealLaterRoll is seen by the DM, and the players see that it happened but not what it was
+	RevealLaterRoll
+)
+
+var rollVisibilityNames = []string{"public", "dm", "reveal-later"}
+
+func (v RollVisibility) String() string {
+	if v < 0 || int(v) >= len(rollVisibilityNames) {
+		return fmt.Sprintf("RollVisibility(%d)", int(v))
+	}
+	return rollVisibilityNames[v]
+}
+
+// ParseRollVisibility reads a visibility written by String
+func ParseRollVisibility(s string) (RollVisibility, error) {
+	for i, name := range rollVisibilityNames {
+		if s == name {
+			return RollVisibility(i), nil
+		}
+	}
+	return PublicRoll, fmt.Errorf("unknown roll visibility '%s'", s)
+}
+
+// RecordedRoll is a roll in the party's history. ID identifies it for revealing.
+type RecordedRoll struct {
+	*dice.RollResult
+	ID         int
+	Visibility RollVisibility
+	// Player is who made the roll from their phone, ChatAuthor who made it in a chat, and both
+	// are empty for the DM. Label is what it was for, if that was said.
+	Player, ChatAuthor, Label string
+}
+
+// Who is who made the roll, to show with it, or empty for the DM
+func (r *RecordedRoll) Who() string {
+	if r.ChatAuthor != "" {
+		return r.ChatAuthor + " (chat)"
+	}
+	return r.Player
+}
+
+// Hidden is whether the players can't see what the roll was
+func (r *RecordedRoll) Hidden() bool {
+	return r.Visibility != PublicRoll
+}
+
+// rollRecord is a roll as the party keeps it
+type rollRecord struct {
+	Result     dice.RollResult
+	Visibility RollVisibility
+	// Player is who made the roll from their phone, ChatAuthor who made it in a chat, and both
+	// are empty for the DM. Label is what it was for, if that was said.
+	Player, ChatAuthor, Label string
+}
+
+// recordedRolls returns the rolls most recent first, leaving out DM only rolls unless asked for
+func (p *party) recordedRolls(dm bool) []*RecordedRoll {
+	r := make([]*RecordedRoll, 0, len(p.RollHistory))
+	for i := len(p.RollHistory) - 1; i >= 0; i-- {
+		record := p.RollHistory[i]
+		if record.Visibility == DMOnlyRoll && !dm {
+			continue
+		}
+		r = append(r, &RecordedRoll{&record.Result, i, record.Visibility, record.Player,
+			record.ChatAuthor, record.Label})
+	}
+	return r
+}
+
+// RecordedRolls returns every roll with who can see it, most recent first
+func (p *party) RecordedRolls() []*RecordedRoll {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.recordedRolls(true)
+}
+
+// PublicRolls returns the rolls the players know about, most recent first. Rolls to be revealed
+// later are included, so check Hidden before showing what they were.
+func (p *party) PublicRolls() []*RecordedRoll {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.recordedRolls(false)
+}
+
+// RevealRollAction makes a hidden roll public
+type RevealRollAction struct {
+	ID       int
+	Previous RollVisibility
+}
+
+// RevealRollAction creates an action revealing a roll
+func (p *party) RevealRollAction(ID int) (*RevealRollAction, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if ID < 0 || ID >= len(p.RollHistory) {
+		return nil, fmt.Errorf("there's no roll %d", ID)
+	}
+	if p.RollHistory[ID].Visibility == PublicRoll {
+		return nil, fmt.Errorf("roll %d is already public", ID)
+	}
+	return &RevealRollAction{ID: ID}, nil
+}
+
+func (a *RevealRollAction) apply(p *party) {
+	a.Previous = p.RollHistory[a.ID].Visibility
+	p.RollHistory[a.ID].Visibility = PublicRoll
+}
+
+func (a *RevealRollAction) undo(p *party) {
+	p.RollHistory[a.ID].Visibility = a.Previous
+}
+
+func (a *RevealRollAction) describe(p *party) string {
+	r := p.RollHistory[a.ID].Result
+	return fmt.Sprintf("Revealed a roll of %v: %d", r.Roll, r.Sum)
+}
@@ -0,0 +1,55 @@
+package party
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHiddenRolls(t *testing.T) {
+	d, err := ioutil.TempDir("", "encounters")
+	if err != nil {
+		t.Fatalf("Couldn't create temporary directory - %v", err)
+	}
+	defer os.RemoveAll(d)
+	p := New(d, "heroes")
+	p.AddRoll(testDiceRoll(1).Simulate(), PublicRoll)
+	p.AddRoll(testDiceRoll(2).Simulate(), DMOnlyRoll)
+	p.AddRoll(testDiceRoll(3).Simulate(), RevealLaterRoll)
+
+	assert.Equal(t, 3, len(p.RecordedRolls()))
+	public := p.PublicRolls()
+	assert.Equal(t, 2, len(public))
+	assert.True(t, public[0].Hidden())
+	assert.Equal(t, 0, public[1].ID)
+	assert.False(t, public[1].Hidden())
+
+	_, err = p.RevealRollAction(0)
+	assert.Error(t, err)
+	_, err = p.RevealRollAction(3)
+	assert.Error(t, err)
+	a, err := p.RevealRollAction(1)
+	assert.NoError(t, err)
+	assert.NoError(t, p.Apply(a))
+	assert.Equal(t, 3, len(p.PublicRolls()))
+	assert.Equal(t, "Revealed a roll of 2: 2", p.SessionLog()[0].Text)
+
+	// Visibilities come back from the journal
+	loaded := saveAndLoad(t, p)
+	assert.Equal(t, 3, len(loaded.PublicRolls()))
+	assert.NoError(t, loaded.Undo())
+	assert.Equal(t, 2, len(loaded.PublicRolls()))
+	assert.Equal(t, DMOnlyRoll, loaded.RecordedRolls()[1].Visibility)
+}
+
+func TestRollVisibilityNames(t *testing.T) {
+	for _, v := range []RollVisibility{PublicRoll, DMOnlyRoll, RevealLaterRoll} {
+		parsed, err := ParseRollVisibility(v.String())
+		assert.NoError(t, err)
+		assert.Equal(t, v, parsed)
+	}
+	_, err := ParseRollVisibility("secret")
+	assert.Error(t, err)
+}
@@ -0,0 +1,439 @@
+package party
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store is somewhere parties are kept. Parties loaded from a store save themselves back to it.
+type Store interface {
+	List() ([]string, error)
+	Load(name string) (Party, error)
+	Save(p Party) error
+	Delete(name string) error
+	Rename(oldName, newName string) error
+}
+
+// ErrNoSuchParty is returned by stores asked for a party they don't have
+var ErrNoSuchParty = errors.New("no such party")
+
+const partyFileSuffix = ".party.gob"
+
+// FileStore keeps each party as a snapshot and journal in a directory, with backups of each in
+// a directory of its own under backups
+type FileStore struct {
+	directory    string
+	BackupPolicy BackupPolicy
+	clock        func() time.Time
+}
+
+// NewFileStore creates a store for parties in a directory, which must already exist
+func NewFileStore(directory string) *FileStore {
+	return &FileStore{directory, DefaultBackupPolicy, nil}
+}
+
+func (s *FileStore) filename(name string) string {
+	return filepath.Join(s.directory, name+partyFileSuffix)
+}
+
+// List returns the names of all the parties in the directory
+func (s *FileStore) List() ([]string, error) {
+	contents, err := ioutil.ReadDir(s.directory)
+	if err != nil {
+		return nil, fmt.Errorf("error when reading data directory - %v", err)
+	}
+	names := make([]string, 0)
+	for _, fileInfo := range contents {
+		if !fileInfo.IsDir() && strings.HasSuffix(fileInfo.Name(), partyFileSuffix) {
+			names = append(names, strings.TrimSuffix(fileInfo.Name(), partyFileSuffix))
+		}
+	}
+	return names, nil
+}
+
+// Load a party from its file
+func (s *FileStore) Load(name string) (Party, error) {
+	file, err := os.Open(s.filename(name))
+	if os.IsNotExist(err) {
+		return nil, ErrNoSuchParty
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	p, err := Load(file)
+	if err != nil {
+		return nil, err
+	}
+	p.(*party).store = s
+	return p, nil
+}
+
+// Save a party to its file in the directory
+func (s *FileStore) Save(p Party) error {
+	pp, ok := p.(*party)
+	if !ok {
+		return fmt.Errorf("can't save %T", p)
+	}
+	pp.mutex.Lock()
+	defer pp.mutex.Unlock()
+	filename := s.filename(pp.name())
+	if pp.Filename != filename {
+		// It was somewhere else before, so its journal is too
+		pp.Filename = filename
+		pp.restartJournal = true
+	}
+	pp.store = s
+	err := s.backUpIfDue(pp)
+	if err != nil {
+		// Not being able to back up is no reason not to save
+		log.Printf("Error backing up party '%s' - %v", pp.name(), err)
+	}
+	return pp.saveFile()
+}
+
+// partyFileRegexp matches what comes after "(name).party." in the names of a party's files: the
+// snapshot, the journal, old journals set aside by recovering the party, and the copies of any of
+// them kept from before a migration
+var partyFileRegexp = regexp.MustCompile(`^(?:gob|journal(?:\.[0-9]+)?)(?:\.v[0-9]+\.bak)?$`)
+
+// files returns every file belonging to a party: its snapshot, journals and backups. Names can
+// have dots in, so only the exact names count: a party called "a.party.x" has files starting
+// "a.party." too.
+func (s *FileStore) files(name string) ([]string, error) {
+	contents, err := ioutil.ReadDir(s.directory)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0)
+	prefix := name + ".party."
+	for _, fileInfo := range contents {
+		if !fileInfo.IsDir() && strings.HasPrefix(fileInfo.Name(), prefix) &&
+			partyFileRegexp.MatchString(strings.TrimPrefix(fileInfo.Name(), prefix)) {
+			files = append(files, fileInfo.Name())
+		}
+	}
+	return files, nil
+}
+
+// Delete removes every file belonging to a party, and its backups
+func (s *FileStore) Delete(name string) error {
+	if !s.exists(name) {
+		return ErrNoSuchParty
+	}
+	files, err := s.files(name)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		err := os.Remove(filepath.Join(s.directory, f))
+		if err != nil {
+			return fmt.Errorf("error deleting '%s' - %v", f, err)
+		}
+	}
+	return os.RemoveAll(s.backupDirectory(name))
+}
+
+// moveParty moves all of a party's files and backups into another store, giving it a new name
+// on the way. The snapshot still has the old name inside it.
+func (s *FileStore) moveParty(to *FileStore, oldName, newName string) error {
+	files, err := s.files(oldName)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		renamed := newName + strings.TrimPrefix(f, oldName)
+		err := os.Rename(filepath.Join(s.directory, f), filepath.Join(to.directory, renamed))
+		if err != nil {
+			return fmt.Errorf("error moving '%s' - %v", f, err)
+		}
+	}
+	if _, err := os.Stat(s.backupDirectory(oldName)); err == nil {
+		err = os.MkdirAll(filepath.Dir(to.backupDirectory(newName)), 0750)
+		if err != nil {
+			return err
+		}
+		err = os.Rename(s.backupDirectory(oldName), to.backupDirectory(newName))
+		if err != nil {
+			return fmt.Errorf("error moving backups - %v", err)
+		}
+	}
+	return nil
+}
+
+func (s *FileStore) exists(name string) bool {
+	_, err := os.Stat(s.filename(name))
+	return err == nil
+}
+
+// Rename moves all of a party's files to its new name, and saves it with the new name inside
+func (s *FileStore) Rename(oldName, newName string) error {
+	if !ValidName(newName) {
+		return fmt.Errorf("'%s' can't be used as a party name", newName)
+	}
+	if !s.exists(oldName) {
+		return ErrNoSuchParty
+	}
+	if s.exists(newName) {
+		return fmt.Errorf("there's already a party called '%s'", newName)
+	}
+	err := s.moveParty(s, oldName, newName)
+	if err != nil {
+		return err
+	}
+	p, err := s.Load(newName)
+	if err != nil {
+		return err
+	}
+	p.(*party).PartyName = newName
+	p.(*party).restartJournal = true
+	return p.Save()
+}
+
+// JournalStore is a store that keeps a journal of what happened to each party, so that a party
+// can be recovered as it was at any time since its journal was started
+type JournalStore interface {
+	Store
+	LoadAt(name string, t time.Time) (Party, error)
+}
+
+// LoadAt recovers a party as it was at a point in time. It becomes the current party when it's
+// saved.
+func (s *FileStore) LoadAt(name string, t time.Time) (Party, error) {
+	if !s.exists(name) {
+		return nil, ErrNoSuchParty
+	}
+	p, err := LoadAt(s.filename(name), t)
+	if err != nil {
+		return nil, err
+	}
+	p.(*party).store = s
+	return p, nil
+}
+
+// ShelfStore is a store that can put parties away without getting rid of them for good: in the
+// trash, or in an archive for finished campaigns that can be brought back out.
+type ShelfStore interface {
+	Store
+	Trash(name string) error
+	Archive(name string) error
+	Unarchive(name string) error
+	Archived() ([]string, error)
+}
+
+func (s *FileStore) archive() *FileStore {
+	return &FileStore{filepath.Join(s.directory, "archive"), s.BackupPolicy, s.clock}
+}
+
+// Trash moves a party and its backups into a folder of their own in the trash directory
+func (s *FileStore) Trash(name string) error {
+	if !s.exists(name) {
+		return ErrNoSuchParty
+	}
+	trash := &FileStore{
+		filepath.Join(s.directory, "trash", name+"-"+s.now().Format(backupTimeFormat)),
+		s.BackupPolicy,
+		s.clock}
+	err := os.MkdirAll(trash.directory, 0750)
+	if err != nil {
+		return err
+	}
+	return s.moveParty(trash, name, name)
+}
+
+// Archive moves a party into the archive directory, where it isn't listed any more
+func (s *FileStore) Archive(name string) error {
+	if !s.exists(name) {
+		return ErrNoSuchParty
+	}
+	archive := s.archive()
+	if archive.exists(name) {
+		return fmt.Errorf("there's already an archived party called '%s'", name)
+	}
+	err := os.MkdirAll(archive.directory, 0750)
+	if err != nil {
+		return err
+	}
+	return s.moveParty(archive, name, name)
+}
+
+// Unarchive moves a party back out of the archive
+func (s *FileStore) Unarchive(name string) error {
+	archive := s.archive()
+	if !archive.exists(name) {
+		return ErrNoSuchParty
+	}
+	if s.exists(name) {
+		return fmt.Errorf("there's already a party called '%s'", name)
+	}
+	return archive.moveParty(s, name, name)
+}
+
+// Archived lists the parties in the archive
+func (s *FileStore) Archived() ([]string, error) {
+	archive := s.archive()
+	if _, err := os.Stat(archive.directory); os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	return archive.List()
+}
+
+// MemoryStore keeps parties in memory, for tests
+type MemoryStore struct {
+	mutex    sync.Mutex
+	parties  map[string][]byte
+	archived map[string][]byte
+	trashed  [][]byte
+}
+
+// NewMemoryStore creates an empty store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{parties: make(map[string][]byte), archived: make(map[string][]byte)}
+}
+
+func sortedNames(parties map[string][]byte) []string {
+	names := make([]string, 0, len(parties))
+	for name := range parties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// List the names of the parties in the store, in alphabetical order
+func (s *MemoryStore) List() ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return sortedNames(s.parties), nil
+}
+
+// Load a copy of a party from the store
+func (s *MemoryStore) Load(name string) (Party, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	snapshot, ok := s.parties[name]
+	if !ok {
+		return nil, ErrNoSuchParty
+	}
+	p := New("", "").(*party)
+	err := gob.NewDecoder(bytes.NewReader(snapshot)).Decode(p)
+	if err != nil {
+		return nil, err
+	}
+	p.store = s
+	return p, nil
+}
+
+// Save a snapshot of a party into the store
+func (s *MemoryStore) Save(p Party) error {
+	pp, ok := p.(*party)
+	if !ok {
+		return fmt.Errorf("can't save %T", p)
+	}
+	pp.mutex.Lock()
+	defer pp.mutex.Unlock()
+	snapshot, err := pp.encodeSnapshot()
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.parties[pp.name()] = snapshot
+	pp.pending = nil
+	pp.store = s
+	return nil
+}
+
+// Delete a party from the store
+func (s *MemoryStore) Delete(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.parties[name]; !ok {
+		return ErrNoSuchParty
+	}
+	delete(s.parties, name)
+	return nil
+}
+
+// Rename a party in the store
+func (s *MemoryStore) Rename(oldName, newName string) error {
+	if !ValidName(newName) {
+		return fmt.Errorf("'%s' can't be used as a party name", newName)
+	}
+	p, err := s.Load(oldName)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	if _, ok := s.parties[newName]; ok {
+		s.mutex.Unlock()
+		return fmt.Errorf("there's already a party called '%s'", newName)
+	}
+	delete(s.parties, oldName)
+	s.mutex.Unlock()
+	p.(*party).PartyName = newName
+	return s.Save(p)
+}
+
+// Trash takes a party out of the store, keeping it in the trash
+func (s *MemoryStore) Trash(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	snapshot, ok := s.parties[name]
+	if !ok {
+		return ErrNoSuchParty
+	}
+	s.trashed = append(s.trashed, snapshot)
+	delete(s.parties, name)
+	return nil
+}
+
+// Archive moves a party into the archive
+func (s *MemoryStore) Archive(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	snapshot, ok := s.parties[name]
+	if !ok {
+		return ErrNoSuchParty
+	}
+	if _, ok := s.archived[name]; ok {
+		return fmt.Errorf("there's already an archived party called '%s'", name)
+	}
+	s.archived[name] = snapshot
+	delete(s.parties, name)
+	return nil
+}
+
+// Unarchive moves a party back out of the archive
+func (s *MemoryStore) Unarchive(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	snapshot, ok := s.archived[name]
+	if !ok {
+		return ErrNoSuchParty
+	}
+	if _, ok := s.parties[name]; ok {
+		return fmt.Errorf("there's already a party called '%s'", name)
+	}
+	s.parties[name] = snapshot
+	delete(s.archived, name)
+	return nil
+}
+
+// Archived lists the parties in the archive, in alphabetical order
+func (s *MemoryStore) Archived() ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return sortedNames(s.archived), nil
+}
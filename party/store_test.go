@@ -0,0 +1,218 @@
+package party
+
+import (
+	"dnd/creature"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+// testStore checks the behaviour every store should have
+func testStore(t *testing.T, s Store) {
+	names, err := s.List()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, names)
+
+	p := New("", "heroes")
+	assert.NoError(t, s.Save(p))
+	p.Apply(&AddCreatureAction{creature.Create("orc", "grom", testDiceRoll(10))})
+	// Once saved to a store, a party saves itself back to it
+	assert.NoError(t, p.Save())
+	assert.NoError(t, s.Save(New("", "villains")))
+
+	names, err = s.List()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"heroes", "villains"}, names)
+
+	loaded, err := s.Load("heroes")
+	assert.NoError(t, err)
+	assert.Equal(t, "grom", loaded.Creatures()[0].Name)
+	assert.True(t, loaded.CanUndo())
+
+	_, err = s.Load("nobody")
+	assert.Equal(t, ErrNoSuchParty, err)
+
+	assert.Error(t, s.Rename("heroes", "villains"))
+	assert.Error(t, s.Rename("heroes", "../escape"))
+	assert.Equal(t, ErrNoSuchParty, s.Rename("nobody", "somebody"))
+	assert.NoError(t, s.Rename("heroes", "legends"))
+	renamed, err := s.Load("legends")
+	assert.NoError(t, err)
+	assert.Equal(t, "legends", renamed.Name())
+	assert.Equal(t, "grom", renamed.Creatures()[0].Name)
+	_, err = s.Load("heroes")
+	assert.Equal(t, ErrNoSuchParty, err)
+
+	assert.NoError(t, s.Delete("villains"))
+	assert.Equal(t, ErrNoSuchParty, s.Delete("villains"))
+	names, err = s.List()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"legends"}, names)
+}
+
+// testShelfStore checks that parties can be trashed, archived and unarchived
+func testShelfStore(t *testing.T, s ShelfStore) {
+	assert.NoError(t, s.Save(New("", "heroes")))
+	assert.NoError(t, s.Save(New("", "villains")))
+
+	assert.NoError(t, s.Archive("heroes"))
+	names, _ := s.List()
+	assert.Equal(t, []string{"villains"}, names)
+	archived, err := s.Archived()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"heroes"}, archived)
+	_, err = s.Load("heroes")
+	assert.Equal(t, ErrNoSuchParty, err)
+
+	// A new party can take the name of an archived one, but then it can't be unarchived
+	assert.NoError(t, s.Save(New("", "heroes")))
+	assert.Error(t, s.Archive("heroes"))
+	assert.Error(t, s.Unarchive("heroes"))
+	assert.NoError(t, s.Trash("heroes"))
+	assert.NoError(t, s.Unarchive("heroes"))
+	archived, _ = s.Archived()
+	assert.Equal(t, []string{}, archived)
+
+	assert.NoError(t, s.Trash("villains"))
+	assert.Equal(t, ErrNoSuchParty, s.Trash("villains"))
+	assert.Equal(t, ErrNoSuchParty, s.Unarchive("villains"))
+	names, _ = s.List()
+	assert.Equal(t, []string{"heroes"}, names)
+}
+
+func TestFileStore(t *testing.T) {
+	d := testingDirectory(t)
+	defer os.RemoveAll(d)
+	testStore(t, NewFileStore(d))
+	files, err := NewFileStore(d).files("villains")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, files)
+}
+
+func TestFileStoreNamesWithDots(t *testing.T) {
+	d := testingDirectory(t)
+	defer os.RemoveAll(d)
+	s := NewFileStore(d)
+	for _, name := range []string{"a", "a.party.x"} {
+		assert.NoError(t, s.Save(New("", name)))
+		// Give each a journal too
+		p, _ := s.Load(name)
+		p.Apply(&AddPlayerAction{name})
+		assert.NoError(t, p.Save())
+	}
+	// A journal set aside by recovering the party is one of its files too
+	ioutil.WriteFile(filepath.Join(d, "a.party.journal.1790000000"), []byte{}, 0640)
+	files, err := s.files("a")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.party.gob", "a.party.journal", "a.party.journal.1790000000"},
+		files)
+
+	assert.NoError(t, s.Rename("a", "b"))
+	assert.NoError(t, s.Delete("b"))
+	p, err := s.Load("a.party.x")
+	assert.NoError(t, err)
+	assert.Equal(t, "a.party.x", p.PlayerInitiatives()[0].Name)
+}
+
+func TestFileShelfStore(t *testing.T) {
+	d := testingDirectory(t)
+	defer os.RemoveAll(d)
+	s := NewFileStore(d)
+	testShelfStore(t, s)
+	trash, err := ioutil.ReadDir(filepath.Join(d, "trash"))
+	assert.NoError(t, err)
+	assert.Len(t, trash, 2)
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+	testShelfStore(t, NewMemoryStore())
+}
+
+// openTestDatabase opens a database store in a new temporary directory
+func openTestDatabase(t *testing.T) (*DatabaseStore, string) {
+	d := testingDirectory(t)
+	s, err := OpenDatabaseStore(filepath.Join(d, "parties.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, d
+}
+
+func TestDatabaseStore(t *testing.T) {
+	s, d := openTestDatabase(t)
+	defer os.RemoveAll(d)
+	testStore(t, s)
+	// Only one process can have the database open
+	filename := filepath.Join(d, "parties.db")
+	_, err := OpenDatabaseStore(filename)
+	assert.Error(t, err)
+	assert.NoError(t, s.Close())
+
+	// Everything is in the one file
+	reopened, err := OpenDatabaseStore(filename)
+	assert.NoError(t, err)
+	names, err := reopened.List()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"legends"}, names)
+	p, err := reopened.Load("legends")
+	assert.NoError(t, err)
+	assert.Equal(t, "grom", p.Creatures()[0].Name)
+	assert.True(t, p.CanUndo())
+	files, err := ioutil.ReadDir(d)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+	assert.NoError(t, reopened.Close())
+
+	ioutil.WriteFile(filename, []byte("not a database"), 0640)
+	_, err = OpenDatabaseStore(filename)
+	assert.Error(t, err)
+}
+
+func TestDatabaseShelfStore(t *testing.T) {
+	s, d := openTestDatabase(t)
+	defer os.RemoveAll(d)
+	defer s.Close()
+	testShelfStore(t, s)
+}
+
+func TestDatabaseStoreMigrates(t *testing.T) {
+	s, d := openTestDatabase(t)
+	defer os.RemoveAll(d)
+	defer s.Close()
+	original, err := ioutil.ReadFile(filepath.Join("testdata", "v2.party.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(partiesBucket).CreateBucket([]byte("v2"))
+		if err != nil {
+			return err
+		}
+		return b.Put(snapshotKey, original)
+	})
+	assert.NoError(t, err)
+
+	p, err := s.Load("v2")
+	assert.NoError(t, err)
+	checkFixtureContents(t, p)
+	assert.Len(t, p.JoinCode(), joinCodeLength)
+	// The party as it was is kept as a backup, and nothing is written outside the database
+	backups, err := s.Backups("v2")
+	assert.NoError(t, err)
+	assert.Len(t, backups, 1)
+	backup, err := s.LoadBackup("v2", backups[0].Name)
+	assert.NoError(t, err)
+	checkFixtureContents(t, backup)
+	files, _ := ioutil.ReadDir(d)
+	assert.Len(t, files, 1)
+
+	assert.NoError(t, p.Save())
+	reloaded, err := s.Load("v2")
+	assert.NoError(t, err)
+	assert.Equal(t, p.JoinCode(), reloaded.JoinCode())
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"dnd/party"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+type contextKey int
+
+const (
+	// partyPrefixKey is the context key for the start of the URL of the party a request is for
+	partyPrefixKey contextKey = iota
+	// csrfTokenKey is the context key for the CSRF token of the DM session a request comes from
+	csrfTokenKey
+)
+
+// partyPrefix returns the URL prefix of the party a request is for, e.g. /p/heroes
+func partyPrefix(r *http.Request) string {
+	prefix, _ := r.Context().Value(partyPrefixKey).(string)
+	return prefix
+}
+
+// partyServers are the servers for the pages of a party. They don't hold on to a party
+// themselves, so one set is shared between all the parties.
+type partyServers struct {
+	dice       *DiceServer
+	encounter  *EncounterServer
+	initiative *InitiativeServer
+	overview   *OverviewServer
+	log        *LogServer
+	backup     *BackupServer
+	players    *PlayerViewServer
+	phone      *PhoneServer
+	api        *APIServer
+}
+
+func newPartyServers(store party.Store, templates *templateSet) (*partyServers, error) {
+	encounterServer, err := NewEncounterServer(templates)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create encounter server - %v", err)
+	}
+	servers := &partyServers{
+		dice:       &DiceServer{templates},
+		encounter:  encounterServer,
+		initiative: &InitiativeServer{templates},
+		log:        &LogServer{templates},
+		backup:     &BackupServer{templates, store},
+		players:    &PlayerViewServer{templates},
+		phone:      &PhoneServer{templates},
+		api:        &APIServer{},
+	}
+	servers.overview = NewOverviewServer(templates, servers.encounter,
+		servers.dice, servers.initiative)
+	return servers, nil
+}
+
+// handler creates the handler for all the pages of one party
+func (s *partyServers) handler(p party.Party) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/initiative/", standardPartyActionHandler(s.initiative, p))
+	mux.Handle("/encounter/", standardPartyActionHandler(s.encounter, p))
+	mux.Handle("/roll/", standardPartyActionHandler(s.dice, p))
+	mux.HandleFunc("/log/export.md", func(w http.ResponseWriter, r *http.Request) {
+		s.log.ServeMarkdown(w, p)
+	})
+	mux.Handle("/log/", standardPartyActionHandler(s.log, p))
+	mux.Handle("/backups/", standardPartyActionHandler(s.backup, p))
+	mux.HandleFunc("/export.json", func(w http.ResponseWriter, r *http.Request) {
+		serveExport(w, p)
+	})
+	mux.Handle("/players/", &standardTemplatedGetHandler{&standardTemplatedPartyGetHandler{p, s.players}})
+	mux.Handle("/phone/", s.phone.handler(p))
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		serveEvents(w, r, p)
+	})
+	mux.Handle("/", standardPartyActionHandler(s.overview, p))
+	return mux
+}
+
+// partyHandler holds the handlers for a party's pages and its API, and the party they were
+// created for. Posts to a party take the write lock, so that the action a post builds is applied
+// to the party it was built from, and everything else takes the read lock, so that a page shows
+// the party as it was at one moment. The event stream is left out, as it stays open for as long
+// as the page does.
+type partyHandler struct {
+	party      party.Party
+	pages, api http.Handler
+	lock       *sync.RWMutex
+}
+
+// serve serves a request with one of the party's handlers, holding the lock it needs. A post to
+// a party that was renamed or put away while it waited for the lock is turned away, as saving
+// the party would bring it back.
+func (h partyHandler) serve(parties *initialisationServer, handler http.Handler,
+	w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/events":
+	case r.Method != "GET" && r.Method != "HEAD":
+		h.lock.Lock()
+		defer h.lock.Unlock()
+		if !parties.isLoaded(h.party) {
+			http.Error(w, "The party was renamed or put away while this was being done",
+				http.StatusConflict)
+			return
+		}
+	default:
+		h.lock.RLock()
+		defer h.lock.RUnlock()
+	}
+	handler.ServeHTTP(w, r)
+}
+
+// partyRouter serves each party's pages under /p/(party name)/, and its API under
+// /api/v1/parties/(party name)/. The handlers for a party are created the first time it's
+// visited, and again if the party has been renamed or replaced since.
+type partyRouter struct {
+	parties  *initialisationServer
+	servers  *partyServers
+	mutex    sync.Mutex
+	handlers map[string]partyHandler
+}
+
+func newPartyRouter(parties *initialisationServer, servers *partyServers) *partyRouter {
+	return &partyRouter{parties: parties, servers: servers, handlers: make(map[string]partyHandler)}
+}
+
+func (pr *partyRouter) handler(name string) (partyHandler, error) {
+	p, err := pr.parties.Party(name)
+	if err != nil {
+		return partyHandler{}, err
+	}
+	pr.mutex.Lock()
+	defer pr.mutex.Unlock()
+	if h, ok := pr.handlers[name]; ok && h.party == p {
+		return h, nil
+	}
+	h := partyHandler{p, pr.servers.handler(p), pr.servers.api.handler(p), pr.parties.lock(p)}
+	pr.handlers[name] = h
+	return h, nil
+}
+
+func (pr *partyRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	prefix := "/p/"
+	api := strings.HasPrefix(r.URL.Path, apiPartiesPrefix)
+	if api {
+		prefix = apiPartiesPrefix
+	}
+	rest := strings.TrimPrefix(r.URL.Path, prefix)
+	slash := strings.Index(rest, "/")
+	if api && slash == -1 {
+		// Scripts don't follow redirects as readily as browsers, so the party is there too
+		slash = len(rest)
+	}
+	if api && slash == 0 {
+		writeAPIError(w, notFound("nothing at '%s'", r.URL.Path))
+		return
+	}
+	if slash == -1 {
+		http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+		return
+	}
+	name := rest[:slash]
+	h, err := pr.handler(name)
+	if api && err != nil {
+		writeAPIError(w, apiPartyError(name, err))
+		return
+	}
+	if err == party.ErrNoSuchParty {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	handler := h.pages
+	if api {
+		handler = h.api
+	}
+	ctx := context.WithValue(r.Context(), partyPrefixKey, "/p/"+url.PathEscape(name))
+	http.StripPrefix(prefix+name, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.serve(pr.parties, handler, w, r)
+	})).ServeHTTP(w, r.WithContext(ctx))
+}
+
+// Party finds a party by name, for the chat bot
+func (pr *partyRouter) Party(name string) (party.Party, error) {
+	return pr.parties.Party(name)
+}
+
+// Change calls change with a party, holding its lock like a post to its pages does, so that the
+// chat bot doesn't change a party while a page is
+func (pr *partyRouter) Change(name string, change func(party.Party)) error {
+	h, err := pr.handler(name)
+	if err != nil {
+		return err
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if !pr.parties.isLoaded(h.party) {
+		return errors.New("the party was renamed or put away")
+	}
+	change(h.party)
+	return nil
+}
+
+// saveAll saves every party that has been visited, waiting for anything being done to it to
+// finish first. It's for shutting down. Parties renamed or put away since are skipped, as saving
+// them would bring back their old files.
+func (pr *partyRouter) saveAll() {
+	pr.mutex.Lock()
+	defer pr.mutex.Unlock()
+	for name, h := range pr.handlers {
+		h.lock.Lock()
+		var err error
+		if pr.parties.isLoaded(h.party) {
+			err = h.party.Save()
+		}
+		h.lock.Unlock()
+		if err != nil {
+			log.Printf("Error saving party '%s' - %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"dnd/chat"
+	"dnd/party"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartyRouter(t *testing.T) {
+	store := party.NewMemoryStore()
+	is, err := newInitialisationServer(store, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, is.createParty("heroes"))
+	router := newPartyRouter(is, &partyServers{})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/p/heroes", nil))
+	assert.Equal(t, http.StatusMovedPermanently, w.Code)
+	assert.Equal(t, "/p/heroes/", w.Header().Get("Location"))
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/p/villains/", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestSaveAll(t *testing.T) {
+	store := party.NewMemoryStore()
+	is, err := newInitialisationServer(store, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, is.createParty("heroes"))
+	assert.NoError(t, is.createParty("villains"))
+	router := newPartyRouter(is, &partyServers{api: &APIServer{}})
+	for _, name := range []string{"heroes", "villains"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", apiPartiesPrefix+name, nil))
+	}
+	heroes, _ := is.Party("heroes")
+	heroes.Apply(&party.AddPlayerAction{"Anya"})
+	villains, _ := is.Party("villains")
+	assert.NoError(t, is.renameParty("villains", "baddies"))
+	villains.Apply(&party.AddPlayerAction{"Boris"})
+
+	router.saveAll()
+	saved, err := store.Load("heroes")
+	assert.NoError(t, err)
+	assert.Len(t, saved.PlayerInitiatives(), 1)
+	// The renamed party isn't saved under its old name
+	_, err = store.Load("villains")
+	assert.Equal(t, party.ErrNoSuchParty, err)
+}
+
+// TestConcurrentRequests is meant to be run with -race
+func TestConcurrentRequests(t *testing.T) {
+	store := party.NewMemoryStore()
+	is, err := newInitialisationServer(store, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, is.createParty("heroes"))
+	servers, err := newPartyServers(store, builtInTemplates(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := newPartyRouter(is, servers)
+
+	post := func(path string, form url.Values) {
+		form.Set("redirectURI", "/p/heroes/")
+		r := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusSeeOther, w.Code)
+	}
+	// Every goroutine damages the same creature
+	post("/p/heroes/encounter/new-creature", url.Values{
+		"creatureType": {"orc"}, "creatureName": {"grom"}, "creatureHitDice": {"2d6"}})
+	heroes, _ := is.Party("heroes")
+	damageField := "damageAmount" + heroes.Creatures()[0].ID
+	var wg sync.WaitGroup
+	// The chat bot rolls at the same time as the pages
+	transport := chat.NewFakeTransport()
+	for i := 0; i < 20; i++ {
+		transport.Say("table", "Colin", "/roll d20 stealth")
+	}
+	transport.Close()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		chat.NewBot(transport, router, "heroes").Run()
+	}()
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				post("/p/heroes/encounter/new-creature", url.Values{
+					"creatureType": {"orc"}, "creatureName": {"grom"}, "creatureHitDice": {"2d6"}})
+				post("/p/heroes/encounter/damage", url.Values{damageField: {"1"}})
+				post("/p/heroes/roll/", url.Values{"roll": {"d20"}})
+				post("/p/heroes/log/note", url.Values{"note": {"hello"}})
+				if j%3 == 0 {
+					post("/p/heroes/undo", url.Values{})
+				}
+				for _, page := range []string{"/p/heroes/", "/p/heroes/log/", "/p/heroes/export.json"} {
+					w := httptest.NewRecorder()
+					router.ServeHTTP(w, httptest.NewRequest("GET", page, nil))
+					assert.Equal(t, http.StatusOK, w.Code)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	p, err := is.Party("heroes")
+	assert.NoError(t, err)
+	// The log and the encounter must agree about how many creatures are left
+	added := 0
+	for _, e := range p.SessionLog() {
+		if strings.Contains(e.Text, "grom (orc) joined the encounter") {
+			if strings.HasPrefix(e.Text, "Undid: ") {
+				added--
+			} else {
+				added++
+			}
+		}
+	}
+	assert.Equal(t, added, len(p.Creatures()))
+	assert.Equal(t, 8*10+20, len(p.Rolls()))
+}
+
+func TestPlayerViewHidesSecrets(t *testing.T) {
+	store := party.NewMemoryStore()
+	is, err := newInitialisationServer(store, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, is.createParty("heroes"))
+	servers, err := newPartyServers(store, builtInTemplates(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := newPartyRouter(is, servers)
+	post := func(path string, form url.Values) {
+		form.Set("redirectURI", "/p/heroes/")
+		r := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		router.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	post("/p/heroes/encounter/new-creature", url.Values{
+		"creatureType": {"orc"}, "creatureName": {"Grommash"}, "creatureHitDice": {"37"}})
+	heroes, _ := is.Party("heroes")
+	post("/p/heroes/encounter/damage", url.Values{"damageAmount" + heroes.Creatures()[0].ID: {"20"}})
+	post("/p/heroes/initiative/", url.Values{
+		"newPlayerName": {"Anya"}, "newPlayerInitiative": {"15"},
+		"newCreatureName": {"Orcs"}, "newCreatureInitiative": {"3"}})
+	post("/p/heroes/initiative/next-turn", url.Values{})
+	post("/p/heroes/roll/", url.Values{"roll": {"1234"}, "visibility": {"dm"}})
+	post("/p/heroes/roll/", url.Values{"roll": {"4321"}, "visibility": {"reveal-later"}})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/p/heroes/players/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	page := w.Body.String()
+	assert.Contains(t, page, "Bloodied")
+	assert.Contains(t, page, `<li class="current">Orcs</li>`)
+	assert.Contains(t, page, "Anya")
+	assert.NotContains(t, page, "Grommash")
+	assert.NotContains(t, page, "17")
+	assert.NotContains(t, page, "<form")
+	assert.NotContains(t, page, "1234")
+	assert.NotContains(t, page, "4321")
+	assert.Contains(t, page, "The DM rolled something")
+}
+
+func TestValidationErrorsForEscapedPartyNames(t *testing.T) {
+	store := party.NewMemoryStore()
+	is, err := newInitialisationServer(store, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	servers, err := newPartyServers(store, builtInTemplates(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := newPartyRouter(is, servers)
+	for _, name := range []string{"heroes", "Curse of Strahd"} {
+		assert.NoError(t, is.createParty(name))
+		page := "/p/" + url.PathEscape(name) + "/roll/"
+		form := url.Values{"roll": {"2x6"}, "redirectURI": {page}}
+		r := httptest.NewRequest("POST", page, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		cookies := w.Result().Cookies()
+		assert.Len(t, cookies, 1)
+		assert.Equal(t, page, cookies[0].Path)
+
+		r = httptest.NewRequest("GET", page, nil)
+		r.AddCookie(cookies[0])
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		assert.Contains(t, w.Body.String(), "isn&#39;t a roll", name)
+	}
+}
+
+func TestMovingPartiesWaitsForChanges(t *testing.T) {
+	store := party.NewMemoryStore()
+	is, err := newInitialisationServer(store, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, is.createParty("heroes"))
+	assert.NoError(t, is.createParty("villains"))
+	servers, err := newPartyServers(store, builtInTemplates(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := newPartyRouter(is, servers)
+
+	// A change in progress holds the party's lock, so the rename waits for it to be saved
+	heroes, _ := is.Party("heroes")
+	lock := is.lock(heroes)
+	lock.Lock()
+	renamed := make(chan error)
+	go func() { renamed <- is.renameParty("heroes", "legends") }()
+	heroes.Apply(&party.AddPlayerAction{"Anya"})
+	assert.NoError(t, heroes.Save())
+	lock.Unlock()
+	assert.NoError(t, <-renamed)
+	names, _ := store.List()
+	assert.Equal(t, []string{"legends", "villains"}, names)
+	legends, _ := store.Load("legends")
+	assert.Len(t, legends.PlayerInitiatives(), 1)
+
+	// A post that got the party before it was put away isn't saved
+	h, err := router.handler("villains")
+	assert.NoError(t, err)
+	assert.NoError(t, is.trashParty("villains"))
+	r := httptest.NewRequest("POST", "/log/note",
+		strings.NewReader(url.Values{"note": {"hello"}, "redirectURI": {"/"}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h.serve(is, h.pages, w, r)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	names, _ = store.List()
+	assert.Equal(t, []string{"legends"}, names)
+	assert.Error(t, router.Change("villains", func(party.Party) {}))
+}
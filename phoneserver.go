@@ -0,0 +1,142 @@
+package main
+
+import (
+	"dnd/dice"
+	"dnd/party"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// PhoneServer is the page players roll their own dice from. They join with the party's join
+// code, and their phone keeps a token saying who they are in a cookie.
+type PhoneServer struct {
+	templates *templateSet
+}
+
+const playerCookie = "player"
+
+// playerCookieAge is how long a phone stays joined. Changing the join code signs everyone out.
+const playerCookieAge = 30 * 24 * time.Hour
+
+type phoneTemplateData struct {
+	PartyName, Player string
+	Locked            bool
+	Players           []string
+	Rolls             []*party.RecordedRoll
+}
+
+// GetTemplate gets the template
+func (s *PhoneServer) GetTemplate() *template.Template {
+	return s.templates.page("phone.html")
+}
+
+// requestPlayer is the player a request comes from, or empty if they haven't joined
+func requestPlayer(r *http.Request, p party.Party) string {
+	cookie, err := r.Cookie(playerCookie)
+	if err != nil {
+		return ""
+	}
+	value, err := url.QueryUnescape(cookie.Value)
+	if err != nil {
+		return ""
+	}
+	separator := strings.LastIndex(value, "|")
+	if separator == -1 {
+		return ""
+	}
+	name, token := value[:separator], value[separator+1:]
+	if !p.CheckPlayerToken(name, token) {
+		return ""
+	}
+	return name
+}
+
+// GenerateTemplateData shows the roll page to players who have joined, and the join form to
+// everyone else
+func (s *PhoneServer) GenerateTemplateData(r *http.Request, p party.Party) interface{} {
+	data := &phoneTemplateData{PartyName: p.Name(), Player: requestPlayer(r, p)}
+	if data.Player == "" {
+		for _, pi := range p.PlayerInitiatives() {
+			data.Players = append(data.Players, pi.Name)
+		}
+		return data
+	}
+	data.Locked = p.PlayerLocked(data.Player)
+	data.Rolls = p.PublicRolls()
+	return data
+}
+
+func setPlayerCookie(w http.ResponseWriter, r *http.Request, value string, age time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     playerCookie,
+		Value:    url.QueryEscape(value),
+		Path:     partyPrefix(r) + "/",
+		MaxAge:   int(age.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// join checks the join code, and gives the phone a token for the player
+func (s *PhoneServer) join(w http.ResponseWriter, r *http.Request, p party.Party) error {
+	code := strings.ToUpper(strings.TrimSpace(r.Form.Get("joinCode")))
+	if code != p.JoinCode() {
+		return invalid("joinCode", "That isn't the join code")
+	}
+	name := r.Form.Get("player")
+	token := p.PlayerToken(name)
+	if !p.CheckPlayerToken(name, token) {
+		return fmt.Errorf("there's no player called '%s'", name)
+	}
+	setPlayerCookie(w, r, name+"|"+token, playerCookieAge)
+	log.Printf("%s joined '%s' from their phone", name, p.Name())
+	return nil
+}
+
+// roll rolls dice for the player the phone belongs to
+func (s *PhoneServer) roll(r *http.Request, p party.Party) error {
+	player := requestPlayer(r, p)
+	if player == "" {
+		return fmt.Errorf("rolling from a phone that hasn't joined")
+	}
+	roll, err := dice.ParseRollString(r.Form.Get("roll"))
+	if err != nil {
+		return invalid("roll", "'%s' isn't a roll - %v", r.Form.Get("roll"), err)
+	}
+	return p.AddPlayerRoll(player, roll.Simulate())
+}
+
+// handler serves the phone pages of a party: the page itself, and posts to /phone/join,
+// /phone/roll and /phone/leave, which all go back to it afterwards
+func (s *PhoneServer) handler(p party.Party) http.Handler {
+	get := &standardTemplatedGetHandler{&standardTemplatedPartyGetHandler{p, s}}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			get.ServeHTTP(w, r)
+			return
+		}
+		err := r.ParseForm()
+		if err == nil {
+			switch r.URL.Path {
+			case "/phone/join":
+				err = s.join(w, r, p)
+			case "/phone/roll":
+				err = s.roll(r, p)
+			case "/phone/leave":
+				setPlayerCookie(w, r, "", -time.Second)
+			default:
+				err = fmt.Errorf("unrecognised endpoint: '%v'", r.URL.Path)
+			}
+		}
+		if err != nil {
+			log.Printf("Error handling post - %v", err)
+			setFlash(w, r, err, partyPrefix(r)+"/phone/")
+		}
+		http.Redirect(w, r, partyPrefix(r)+"/phone/", http.StatusSeeOther)
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"dnd/party"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPhoneRolls(t *testing.T) {
+	store := party.NewMemoryStore()
+	is, err := newInitialisationServer(store, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, is.createParty("heroes"))
+	p, _ := is.Party("heroes")
+	p.Apply(&party.AddPlayerAction{"Anya"})
+	servers, err := newPartyServers(store, builtInTemplates(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := newPartyRouter(is, servers)
+
+	post := func(path string, form url.Values, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		assert.Equal(t, "/p/heroes/phone/", w.Header().Get("Location"))
+		return w
+	}
+
+	w := post("/p/heroes/phone/join", url.Values{"joinCode": {"WRONG1"}, "player": {"Anya"}}, nil)
+	// The only cookie is the flash saying it was the wrong code
+	cookies := w.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	assert.Equal(t, flashCookie, cookies[0].Name)
+	w = post("/p/heroes/phone/join",
+		url.Values{"joinCode": {strings.ToLower(p.JoinCode())}, "player": {"Anya"}}, nil)
+	cookies = w.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	assert.Equal(t, "/p/heroes/", cookies[0].Path)
+
+	// Rolling without having joined does nothing
+	post("/p/heroes/phone/roll", url.Values{"roll": {"d20"}}, nil)
+	assert.Empty(t, p.Rolls())
+	post("/p/heroes/phone/roll", url.Values{"roll": {"d20"}}, cookies)
+	assert.Equal(t, "Anya", p.RecordedRolls()[0].Player)
+
+	r := httptest.NewRequest("GET", "/p/heroes/phone/", nil)
+	r.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Contains(t, w.Body.String(), "<h2>Anya</h2>")
+
+	lock, err := p.LockPlayerAction("Anya", true)
+	assert.NoError(t, err)
+	p.Apply(lock)
+	post("/p/heroes/phone/roll", url.Values{"roll": {"d20"}}, cookies)
+	assert.Len(t, p.Rolls(), 1)
+
+	// A new join code signs the phone out
+	p.NewJoinCode()
+	r = httptest.NewRequest("GET", "/p/heroes/phone/", nil)
+	r.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Contains(t, w.Body.String(), "Join code")
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"dnd/party"
+	"html/template"
+	"net/http"
+)
+
+// PlayerViewServer shows the players what they'd be able to see at the table: the turn order,
+// the rolls that weren't made in secret and roughly how hurt the monsters look. It's read only, so it can
+// go on a screen everyone can see.
+type PlayerViewServer struct {
+	templates *templateSet
+}
+
+type playerViewCreature struct {
+	Type, Health, HealthClass string
+}
+
+type playerViewTemplateData struct {
+	PartyName string
+	TurnOrder []*turnInformation
+	Creatures []*playerViewCreature
+	Rolls     []*party.RecordedRoll
+}
+
+// vagueHealth is what the players are told about a creature with each health class
+var vagueHealth = map[string]string{
+	"":        "Healthy",
+	"damaged": "Bloodied",
+	"dead":    "Near death",
+}
+
+// GetTemplate gets the template
+func (s *PlayerViewServer) GetTemplate() *template.Template {
+	return s.templates.page("players.html")
+}
+
+// GenerateTemplateData gets what the players can see, newest creatures first like the encounter
+func (s *PlayerViewServer) GenerateTemplateData(r *http.Request, p party.Party) interface{} {
+	creatures := p.Creatures()
+	data := &playerViewTemplateData{
+		p.Name(),
+		turnOrderInformation(p),
+		make([]*playerViewCreature, len(creatures)),
+		p.PublicRolls()}
+	for i, c := range creatures {
+		class := healthClass(c)
+		data.Creatures[len(creatures)-1-i] = &playerViewCreature{c.Type.Name, vagueHealth[class], class}
+	}
+	return data
+}
@@ -1,14 +1,20 @@
 package main
 
 import (
+	"context"
+	"dnd/chat"
 	"dnd/party"
+	"flag"
 	"fmt"
 	"html/template"
 	"log"
 	"math/rand"
 	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -44,12 +50,25 @@ type standardTemplatedGetHandler struct {
 
 func (h *standardTemplatedGetHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	data := h.GenerateTemplateData(r)
-	temp := h.GetTemplate()
-	temp = temp.Funcs(template.FuncMap{"redirectURIInput": func() template.HTML {
-		input := "<input type=\"hidden\" name=\"redirectURI\" value=\"" + r.RequestURI + "\" />"
-		return template.HTML(input)
-	}})
-	err := temp.Execute(w, data)
+	// The template is shared between requests, so the functions for this one go on a copy
+	temp, err := h.GetTemplate().Clone()
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	temp = temp.Funcs(takeFlash(w, r).funcs()).Funcs(template.FuncMap{
+		"redirectURIInput": func() template.HTML {
+			input := "<input type=\"hidden\" name=\"redirectURI\" value=\"" + r.RequestURI + "\" />"
+			return template.HTML(input)
+		},
+		"partyURL": func(path string) string {
+			return partyPrefix(r) + path
+		},
+		"csrfInput": func() template.HTML {
+			input := "<input type=\"hidden\" name=\"" + csrfTokenField + "\" value=\"" + csrfToken(r) + "\" />"
+			return template.HTML(input)
+		}})
+	err = temp.Execute(w, data)
 	if err != nil {
 		log.Print(err)
 	}
@@ -71,10 +90,21 @@ func (h *standardTemplatedPartyGetHandler) GenerateTemplateData(r *http.Request)
 	return h.TemplatedPartyGetHandler.GenerateTemplateData(r, h.party)
 }
 
+// maxUploadSize is the most memory used for an uploaded file before it goes to disk
+const maxUploadSize = 1 << 20
+
+// parseForm parses a request's form, whether it's a plain one or has files in it
+func parseForm(r *http.Request) error {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		return r.ParseMultipartForm(maxUploadSize)
+	}
+	return r.ParseForm()
+}
+
 // ParseFormAndGetRedirectURI parses the form associated with an HTTP request, and returns the URI
 // to redirect to after finishing handling the request. It returns "/" in case of an error.
 func ParseFormAndGetRedirectURI(r *http.Request) (string, error) {
-	err := r.ParseForm()
+	err := parseForm(r)
 	if err != nil {
 		return "/", err
 	}
@@ -105,6 +135,7 @@ func (h *standardRedirectPostHandler) ServeHTTP(w http.ResponseWriter, r *http.R
 	err = h.HandlePost(r)
 	if err != nil {
 		log.Printf("Error handling post - %v", err)
+		setFlash(w, r, err, redirectURI)
 	}
 	http.Redirect(w, r, redirectURI, http.StatusSeeOther)
 }
@@ -158,70 +189,135 @@ func standardPartyActionHandler(h TemplatedGetPartyActionHandler, p party.Party)
 		&standardRedirectPostHandler{&partyActionRedirectPostHandler{p, h}}}
 }
 
-func loadTemplate(name string) *template.Template {
-	// This rigamorale implements template inheritance. frame is the template we want to execute
-	// but with different templates defined from HeadContent and BodyContent.
-	t := template.New("frame.html.tmpl")
-	// This gets overwritten at the call site, but we can't parse a template with a missing function
-	// for some reason. This is the simplest func that works nil or no results don't
-	t = t.Funcs(template.FuncMap{"redirectURIInput": func() string { return "DEADBEEF" }})
-	// This is clumsy, but is to set empty default implementations
-	t, err := t.Parse(`{{define "HeadContent"}}{{end}}{{define "BodyContent"}}{{end}}`)
+// serveExport sends a party as a JSON file download
+func serveExport(w http.ResponseWriter, p party.Party) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=\"%s.party.json\"", p.Name()))
+	err := p.Export(w)
 	if err != nil {
-		log.Fatalf("Error parsing empty content templates '%s' - %v", name, err)
+		log.Printf("Error exporting party - %v", err)
 	}
-	t, err = t.ParseFiles("templates/frame.html.tmpl", "templates/"+name+".tmpl")
-	if err != nil {
-		log.Fatalf("Error loading templates from file '%s' - %v", name, err)
-	}
-	return t
 }
 
+// shutdownTimeout is how long to wait for requests to finish when shutting down
+const shutdownTimeout = 5 * time.Second
+
+// templateWatchInterval is how often to check whether the templates have changed in dev mode
+const templateWatchInterval = time.Second
+
 func main() {
 	rand.Seed(time.Now().UTC().UnixNano())
 
-	initialisationServer, err := newInitialisationServer(getDataDir(), loadTemplate("choosegroup.html"))
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			err := cmd(os.Args[2:], os.Stdout)
+			if err == errUsage {
+				os.Exit(2)
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	c, err := loadConfig(os.Args[1:], os.Getenv)
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Bad configuration - %v", err)
+	}
+	templateFiles, static := useAssets(c)
+	templates, err := newTemplateSet(templateFiles)
+	if err != nil && !c.Dev {
+		log.Fatalf("Couldn't load the templates - %v", err)
+	}
+	if c.Dev {
+		// Errors show on the pages until they're fixed
+		if err != nil {
+			log.Print(err)
+		}
+		go templates.watch(templateWatchInterval)
+	}
+	dataDir := getDataDir(c.DataDir)
+	unlock, err := lockDataDir(dataDir)
+	if err != nil {
+		log.Fatalf("Can't start - %v", err)
+	}
+	defer unlock()
+	password, err := c.dmPassword()
+	if err != nil {
+		log.Fatalf("Bad configuration - %v", err)
+	}
+	auth, err := newDMAuth(dataDir, password, templates)
+	if err != nil {
+		log.Fatalf("Couldn't set up the DM password - %v", err)
+	}
+	store, closeStore, err := c.openStore(dataDir)
+	if err != nil {
+		log.Fatalf("Couldn't open the party database - %v", err)
+	}
+	defer closeStore()
+	initialisationServer, err := newInitialisationServer(store, templates)
 	if err != nil {
 		log.Fatalf("Catacylsmic error initialising - %v", err)
 	}
-	initialisationHandler := standardTemplatedGetRedirectPostHandler(initialisationServer)
 
-	diceTemplate := loadTemplate("roll.html")
-	encounterTemplate := loadTemplate("encounter.html")
-	overviewTemplate := loadTemplate("overview.html")
-	initiativeEntryTemplate := loadTemplate("initiative.html")
+	servers, err := newPartyServers(store, templates)
+	if err != nil {
+		log.Fatalf("Couldn't create party servers - %v", err)
+	}
 
-	logicServer := http.NewServeMux()
 	server := http.NewServeMux()
 	server.HandleFunc("/favicon.ico", http.NotFound)
-	server.Handle("/static/", http.StripPrefix("/static", http.FileServer(http.Dir("static"))))
-	server.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if initialisationServer.InitialisationComplete {
-			logicServer.ServeHTTP(w, r)
-		} else {
-			initialisationHandler.ServeHTTP(w, r)
-			if initialisationServer.InitialisationComplete {
-				initiativeServer := InitiativeServer{initiativeEntryTemplate}
-				diceServer := DiceServer{diceTemplate, initialisationServer.Party}
-				encounterServer, err := NewEncounterServer(encounterTemplate)
-				if err != nil {
-					log.Fatalf("Couldn't create encounter server - %v", err)
-				}
-				overviewServer := NewOverviewServer(overviewTemplate, encounterServer, &diceServer,
-					&initiativeServer)
-				logicServer.Handle("/initiative/",
-					standardPartyActionHandler(&initiativeServer, initialisationServer.Party))
-				logicServer.Handle("/encounter/",
-					standardPartyActionHandler(encounterServer, initialisationServer.Party))
-				logicServer.Handle("/roll/", standardTemplatedGetRedirectPostHandler(&diceServer))
-				logicServer.Handle("/",
-					standardPartyActionHandler(overviewServer, initialisationServer.Party))
-			}
+	server.Handle("/static/", http.StripPrefix("/static", http.FileServer(http.FS(static))))
+	router := newPartyRouter(initialisationServer, servers)
+	server.Handle("/p/", router)
+	server.Handle("/api/v1/parties/", router)
+	server.Handle("/api/v1/parties", servers.api.ServeParties(store))
+	server.Handle("/api/v1/", servers.api.ServeParties(store))
+	server.HandleFunc("/export/", initialisationServer.ServeExport)
+	server.HandleFunc("/login", auth.ServeLogin)
+	server.HandleFunc("/logout", auth.ServeLogout)
+	server.Handle("/", standardTemplatedGetRedirectPostHandler(initialisationServer))
+
+	if c.ChatIRC != "" {
+		transport, err := chat.DialIRC(c.ChatIRC, c.ChatNick)
+		if err != nil {
+			log.Fatalf("Couldn't start the chat bot - %v", err)
 		}
-	})
-	log.Print("Starting encounter server on localhost:1212...")
-	err = http.ListenAndServe("localhost:1212", server)
-	if err != nil {
+		defer transport.Close()
+		go chat.NewBot(transport, router, c.ChatParty).Run()
+	}
+
+	httpServer := &http.Server{Addr: c.Listen, Handler: auth.handler(server)}
+	stopped := make(chan struct{})
+	go func() {
+		interrupts := make(chan os.Signal, 1)
+		signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+		<-interrupts
+		log.Print("Shutting down...")
+		// Event streams stay open until the page is closed, so don't wait for them for long
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := httpServer.Shutdown(ctx); err != nil {
+			httpServer.Close()
+		}
+		router.saveAll()
+		close(stopped)
+	}()
+	if c.TLSCert != "" {
+		log.Printf("Starting encounter server on https://%s...", c.Listen)
+		err = httpServer.ListenAndServeTLS(c.TLSCert, c.TLSKey)
+	} else {
+		log.Printf("Starting encounter server on http://%s...", c.Listen)
+		err = httpServer.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
 		log.Fatal(err)
 	}
+	<-stopped
 }
@@ -1,7 +1,12 @@
 package main
 
 import (
+	"errors"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -13,3 +18,71 @@ func TestGetURLFunction(t *testing.T) {
 	arg, _ = getURLArgument(&url.URL{Path: "/bar/foo"})
 	assert.Equal(t, "foo", arg)
 }
+
+type failingPostHandler struct {
+	err error
+}
+
+func (h *failingPostHandler) HandlePost(r *http.Request) error {
+	return h.err
+}
+
+type flashPage struct {
+	template *template.Template
+}
+
+func (p *flashPage) GetTemplate() *template.Template {
+	return p.template
+}
+
+func (p *flashPage) GenerateTemplateData(r *http.Request) interface{} {
+	return nil
+}
+
+func TestValidationErrorsSurviveRedirect(t *testing.T) {
+	post := func(err error) *http.Cookie {
+		form := url.Values{"redirectURI": {"/roll/?tab=1"}, "roll": {"2x6"}}
+		r := httptest.NewRequest("POST", "/roll/", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		(&standardRedirectPostHandler{&failingPostHandler{err}}).ServeHTTP(w, r)
+		assert.Equal(t, http.StatusSeeOther, w.Code)
+		cookies := w.Result().Cookies()
+		if len(cookies) != 1 {
+			return nil
+		}
+		return cookies[0]
+	}
+	page := template.Must(template.New("page").Funcs((&flash{}).funcs()).Funcs(template.FuncMap{
+		"redirectURIInput": func() string { return "" },
+		"partyURL":         func(path string) string { return path },
+		"csrfInput":        func() string { return "" },
+	}).Parse(`{{fieldValue "roll" "d20"}}|{{fieldError "roll"}}|{{formError}}`))
+	get := func(path string, cookie *http.Cookie) (string, *httptest.ResponseRecorder) {
+		r := httptest.NewRequest("GET", path, nil)
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		(&standardTemplatedGetHandler{&flashPage{page}}).ServeHTTP(w, r)
+		return w.Body.String(), w
+	}
+
+	body, _ := get("/roll/", nil)
+	assert.Equal(t, "d20||", body)
+	cookie := post(invalid("roll", "not a roll"))
+	assert.NotNil(t, cookie)
+	assert.Equal(t, "/roll/", cookie.Path)
+	// It's only for the page redirected to
+	body, w := get("/roll/history/", cookie)
+	assert.Equal(t, "d20||", body)
+	assert.Empty(t, w.Result().Cookies())
+	body, w = get("/roll/", cookie)
+	assert.Equal(t, "2x6|not a roll|", body)
+	// It's only shown once
+	assert.Equal(t, -1, w.Result().Cookies()[0].MaxAge)
+	assert.Equal(t, "/roll/", w.Result().Cookies()[0].Path)
+
+	body, _ = get("/roll/", post(errors.New("something broke")))
+	assert.Equal(t, "2x6||something broke", body)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"sync"
+	"time"
+)
+
+// pageNames are the pages with templates of their own
+var pageNames = []string{"choosegroup.html", "login.html", "encounter.html", "roll.html",
+	"initiative.html", "log.html", "backups.html", "players.html", "phone.html", "overview.html"}
+
+// templateSet is the template for every page. They're loaded together, so that in dev mode they
+// can all be reloaded when one changes: that's why servers look up their template each time they
+// render a page rather than keeping hold of one.
+type templateSet struct {
+	fsys  fs.FS
+	mutex sync.RWMutex
+	pages map[string]*template.Template
+	// stamp is the names and times of the files the pages were loaded from, to tell when they
+	// change
+	stamp string
+}
+
+// newTemplateSet loads the templates in a file system. If there's an error in them, every page
+// shows the error instead.
+func newTemplateSet(fsys fs.FS) (*templateSet, error) {
+	s := &templateSet{fsys: fsys}
+	err := s.load()
+	return s, err
+}
+
+func loadTemplate(fsys fs.FS, name string) (*template.Template, error) {
+	// This rigamorale implements template inheritance. frame is the template we want to execute
+	// but with different templates defined from HeadContent and BodyContent.
+	t := template.New("frame.html.tmpl")
+	// This gets overwritten at the call site, but we can't parse a template with a missing function
+	// for some reason. This is the simplest func that works nil or no results don't
+	t = t.Funcs(template.FuncMap{
+		"redirectURIInput": func() string { return "DEADBEEF" },
+		"partyURL":         func(path string) string { return path },
+		"csrfInput":        func() string { return "DEADBEEF" }})
+	t = t.Funcs((&flash{}).funcs())
+	// This is clumsy, but is to set empty default implementations
+	t, err := t.Parse(`{{define "HeadContent"}}{{end}}{{define "BodyContent"}}{{end}}`)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing empty content templates '%s' - %v", name, err)
+	}
+	t, err = t.ParseFS(fsys, "frame.html.tmpl", name+".tmpl")
+	if err != nil {
+		return nil, fmt.Errorf("error loading templates from file '%s' - %v", name, err)
+	}
+	return t, nil
+}
+
+// attachPrefixedTemplate is a bit complicated. I've implemented this slightly crazy template
+// inheritance system. In order to do this overview pages, I want to render a bunch of different
+// BodyContent templates from other templates. To do this, I have to prefix them with a source,
+// and then preserve the original root of the template.
+func attachPrefixedTemplate(root, child *template.Template, prefix string) (*template.Template, error) {
+	rootName := root.Name()
+	t, err := root.AddParseTree(prefix+child.Name(), child.Tree)
+	if err != nil {
+		return nil, err
+	}
+	return t.Lookup(rootName), nil
+}
+
+// attachOverviewTemplates gives the overview the content of the pages it's made up of
+func attachOverviewTemplates(pages map[string]*template.Template) error {
+	attachments := []struct{ page, content, prefix string }{
+		{"encounter.html", "BodyContent", "Encounter"},
+		{"roll.html", "BodyContent", "Roll"},
+		{"roll.html", "HeadContent", "Roll"},
+		{"initiative.html", "BodyContent", "Initiative"},
+	}
+	t := pages["overview.html"]
+	for _, a := range attachments {
+		var err error
+		t, err = attachPrefixedTemplate(t, pages[a.page].Lookup(a.content), a.prefix)
+		if err != nil {
+			return fmt.Errorf("error attaching %s %s to the overview - %v", a.page, a.content, err)
+		}
+	}
+	pages["overview.html"] = t
+	return nil
+}
+
+// errorTemplate is a page that shows an error loading the templates
+func errorTemplate(err error) *template.Template {
+	return template.Must(template.New("error").Funcs(template.FuncMap{
+		"templateError": func() string { return err.Error() },
+	}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="UTF-8"><title>Template error</title></head>
+<body><h1>Template error</h1><pre>{{templateError}}</pre></body>
+</html>`))
+}
+
+// load (re)loads every page. If there's an error, every page is replaced with one showing it.
+func (s *templateSet) load() error {
+	stamp, stampErr := s.currentStamp()
+	pages := make(map[string]*template.Template)
+	var err error
+	for _, name := range pageNames {
+		pages[name], err = loadTemplate(s.fsys, name)
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = attachOverviewTemplates(pages)
+	}
+	if err != nil {
+		for _, name := range pageNames {
+			pages[name] = errorTemplate(err)
+		}
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.pages = pages
+	if stampErr == nil {
+		s.stamp = stamp
+	}
+	return err
+}
+
+// page gets the template for a page
+func (s *templateSet) page(name string) *template.Template {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.pages[name]
+}
+
+// currentStamp is the names and modification times of all the files in the template directory
+func (s *templateSet) currentStamp() (string, error) {
+	stamp := ""
+	err := fs.WalkDir(s.fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		stamp += fmt.Sprintf("%s %v\n", path, info.ModTime().UnixNano())
+		return nil
+	})
+	return stamp, err
+}
+
+// changed is whether any of the template files have changed since they were loaded
+func (s *templateSet) changed() bool {
+	stamp, err := s.currentStamp()
+	if err != nil {
+		return false
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return stamp != s.stamp
+}
+
+// watch reloads the templates whenever they change, checking every interval. It's for dev mode,
+// and never returns.
+func (s *templateSet) watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		if !s.changed() {
+			continue
+		}
+		err := s.load()
+		if err != nil {
+			log.Printf("Error reloading templates - %v", err)
+		} else {
+			log.Print("Reloaded templates")
+		}
+	}
+}
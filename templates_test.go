@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// builtInTemplates loads the templates built into the binary
+func builtInTemplates(t *testing.T) *templateSet {
+	templates, err := newTemplateSet(subFS(embeddedAssets, "templates"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return templates
+}
+
+func TestTemplateReload(t *testing.T) {
+	d, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	for _, name := range append(pageNames, "frame.html") {
+		data, err := embeddedAssets.ReadFile("templates/" + name + ".tmpl")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.WriteFile(filepath.Join(d, name+".tmpl"), data, 0640)
+	}
+	templates, err := newTemplateSet(os.DirFS(d))
+	assert.NoError(t, err)
+	assert.False(t, templates.changed())
+	render := func() string {
+		w := httptest.NewRecorder()
+		(&standardTemplatedGetHandler{&dmAuth{templates: templates}}).ServeHTTP(w,
+			httptest.NewRequest("GET", "/login", nil))
+		return w.Body.String()
+	}
+	assert.Contains(t, render(), "Password")
+
+	// Make sure the modification time is different even on coarse file systems
+	later := time.Now().Add(time.Minute)
+	login := filepath.Join(d, "login.html.tmpl")
+	ioutil.WriteFile(login, []byte(`{{define "BodyContent"}}{{if}}{{end}}`), 0640)
+	os.Chtimes(login, later, later)
+	assert.True(t, templates.changed())
+	assert.Error(t, templates.load())
+	assert.Contains(t, render(), "Template error")
+
+	ioutil.WriteFile(login, []byte(`{{define "BodyContent"}}Welcome back{{end}}`), 0640)
+	later = later.Add(time.Minute)
+	os.Chtimes(login, later, later)
+	assert.NoError(t, templates.load())
+	assert.Contains(t, render(), "Welcome back")
+	// The overview is put back together too
+	assert.NotNil(t, templates.page("overview.html").Lookup("EncounterBodyContent"))
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"dnd/creature"
+	"dnd/dice"
+	"dnd/party"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// terminalUI runs combat for a party in a terminal, for when there's no browser. It redraws the
+// whole screen after every command. Commands are single keys if the terminal can be switched to
+// reading them as they're pressed, and whole lines if not, e.g. when the input is a file.
+type terminalUI struct {
+	party party.Party
+	out   io.Writer
+	// message is the result of the last command, shown under everything else
+	message string
+	// keys is whether commands are single keys rather than lines
+	keys bool
+}
+
+const (
+	clearScreen = "\x1b[H\x1b[2J"
+	resetColour = "\x1b[0m"
+	// hpBarWidth is how many characters a creature's HP bar is at full health
+	hpBarWidth = 20
+)
+
+// healthColours are the terminal colours for the classes of healthClass
+var healthColours = map[string]string{
+	"":        "\x1b[32m",
+	"damaged": "\x1b[33m",
+	"dead":    "\x1b[31m",
+}
+
+const (
+	tuiHelp = "n: next turn   u: undo   r: redo   d <creature ID> <amount>: damage   " +
+		"<dice, e.g. 2d6 + 3>: roll   q: quit"
+	tuiKeysHelp = "n: next turn   u: undo   r: redo   d: damage   0-9: roll, e.g. 2d6 + 3   q: quit"
+)
+
+// Keys that mean something while reading keys
+const (
+	keyInterrupt = 3
+	keyEndOfFile = 4
+	keyBackspace = 8
+	keyEscape    = 27
+	keyDelete    = 127
+)
+
+func tuiCommand(args []string, out io.Writer) error {
+	store, done, args, err := openStore(newCommandFlags("tui", out), args, true)
+	if err != nil {
+		return err
+	}
+	defer done()
+	if len(args) != 1 {
+		return usage(out, "tui [--data-dir dir] [--database file] <party>")
+	}
+	p, err := store.Load(args[0])
+	if err != nil {
+		return err
+	}
+	ui := &terminalUI{party: p, out: out}
+	restore, err := readKeys(os.Stdin)
+	if err != nil {
+		return ui.run(os.Stdin)
+	}
+	defer restore()
+	ui.keys = true
+	return ui.runKeys(os.Stdin)
+}
+
+// readKeys switches a terminal to passing on keys as they're pressed, without showing them, and
+// returns how to switch it back. It uses stty, so that it needs nothing outside the standard
+// library, and fails if that isn't there or the file isn't a terminal.
+func readKeys(terminal *os.File) (restore func(), err error) {
+	stty := func(args ...string) ([]byte, error) {
+		cmd := exec.Command("stty", args...)
+		cmd.Stdin = terminal
+		return cmd.Output()
+	}
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, err
+	}
+	// Ctrl-C comes through as a key rather than killing the program, so the terminal can be put
+	// back
+	_, err = stty("cbreak", "-echo", "-isig")
+	if err != nil {
+		return nil, err
+	}
+	return func() { stty(strings.TrimSpace(string(saved))) }, nil
+}
+
+// hpBar draws how much health a creature has left
+func hpBar(c *creature.Creature) string {
+	left := c.RolledHealth - c.DamageTaken
+	filled := 0
+	if left > 0 && c.RolledHealth > 0 {
+		filled = (left*hpBarWidth + c.RolledHealth - 1) / c.RolledHealth
+	}
+	if filled > hpBarWidth {
+		filled = hpBarWidth
+	}
+	return healthColours[healthClass(c)] + "[" + strings.Repeat("#", filled) +
+		strings.Repeat("-", hpBarWidth-filled) + "]" + resetColour
+}
+
+func (ui *terminalUI) draw() {
+	p := ui.party
+	fmt.Fprint(ui.out, clearScreen)
+	fmt.Fprintf(ui.out, "%s\n\nTurn order\n", p.Name())
+	for i, ci := range p.TurnOrder() {
+		marker := " "
+		if i == p.CurrentTurn() {
+			marker = ">"
+		}
+		fmt.Fprintf(ui.out, "%s %3d  %s\n", marker, ci.Initiative, ci.Name)
+	}
+	fmt.Fprintln(ui.out, "\nEncounter")
+	for _, c := range p.Creatures() {
+		fmt.Fprintf(ui.out, "  %s  %s %3d/%-3d  %s %s\n", c.ID, hpBar(c),
+			c.RolledHealth-c.DamageTaken, c.RolledHealth, c.Type.Name, c.Name)
+	}
+	fmt.Fprintln(ui.out, "\nRolls")
+	rolls := p.RecordedRolls()
+	if len(rolls) > showRolls {
+		rolls = rolls[:showRolls]
+	}
+	for _, r := range rolls {
+		fmt.Fprintf(ui.out, "  %s: %s = %d\n", r.Roll, r.StringIndividualRolls(), r.Sum)
+	}
+	help := tuiHelp
+	if ui.keys {
+		help = tuiKeysHelp
+	}
+	fmt.Fprintf(ui.out, "\n%s\n%s\n> ", ui.message, help)
+}
+
+// run reads commands until the input ends or the user quits
+func (ui *terminalUI) run(in io.Reader) error {
+	lines := bufio.NewScanner(in)
+	ui.draw()
+	for lines.Scan() {
+		line := strings.TrimSpace(lines.Text())
+		if line == "q" {
+			break
+		}
+		err := ui.handle(line)
+		if err != nil {
+			ui.message = "Error: " + err.Error()
+		}
+		ui.draw()
+	}
+	fmt.Fprintln(ui.out)
+	return lines.Err()
+}
+
+// runKeys reads single key commands until the input ends or the user quits. Damage and rolls
+// need more than a key, so the rest of them is read as a line, which escape cancels.
+func (ui *terminalUI) runKeys(in io.Reader) error {
+	keys := bufio.NewReader(in)
+	ui.draw()
+	for {
+		key, _, err := keys.ReadRune()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		var line string
+		switch {
+		case key == 'q' || key == keyInterrupt || key == keyEndOfFile:
+			fmt.Fprintln(ui.out)
+			return nil
+		case key == 'n' || key == 'u' || key == 'r':
+			line = string(key)
+		case key == 'd':
+			fmt.Fprint(ui.out, "damage <creature ID> <amount>: ")
+			line, err = ui.readLine(keys, "")
+			if line != "" {
+				line = "d " + line
+			}
+		case key >= '0' && key <= '9':
+			line, err = ui.readLine(keys, string(key))
+		default:
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		err = ui.handle(line)
+		if err != nil {
+			ui.message = "Error: " + err.Error()
+		}
+		ui.draw()
+	}
+	fmt.Fprintln(ui.out)
+	return nil
+}
+
+// readLine reads the rest of a line of input while reading keys, showing it as it's typed. It
+// starts with what's already been typed, and returns nothing if escape is pressed.
+func (ui *terminalUI) readLine(keys *bufio.Reader, typed string) (string, error) {
+	line := []rune(typed)
+	fmt.Fprint(ui.out, typed)
+	for {
+		key, _, err := keys.ReadRune()
+		if err != nil {
+			return strings.TrimSpace(string(line)), err
+		}
+		switch key {
+		case '\r', '\n':
+			return strings.TrimSpace(string(line)), nil
+		case keyEscape, keyInterrupt:
+			return "", nil
+		case keyBackspace, keyDelete:
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+				fmt.Fprint(ui.out, "\b \b")
+			}
+		default:
+			line = append(line, key)
+			fmt.Fprint(ui.out, string(key))
+		}
+	}
+}
+
+// handle carries out one command, saving the party if it changed
+func (ui *terminalUI) handle(line string) error {
+	p := ui.party
+	fields := strings.Fields(line)
+	var err error
+	switch {
+	case line == "":
+		ui.message = ""
+		return nil
+	case line == "n":
+		err = p.Apply(&party.NextTurnAction{})
+		ui.message = "Next turn"
+	case line == "u":
+		err = p.Undo()
+		ui.message = "Undone"
+	case line == "r":
+		err = p.Redo()
+		ui.message = "Redone"
+	case fields[0] == "d":
+		if len(fields) != 3 {
+			return fmt.Errorf("damage is d <creature ID> <amount>")
+		}
+		amount, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return fmt.Errorf("'%s' isn't a number", fields[2])
+		}
+		err = p.Apply(&party.DamageCreatureAction{fields[1], amount})
+		if err != nil {
+			return err
+		}
+		c := p.Creature(fields[1])
+		ui.message = fmt.Sprintf("%s has %d/%d HP", c.ID, c.RolledHealth-c.DamageTaken,
+			c.RolledHealth)
+	default:
+		roll, err := dice.ParseRollString(line)
+		if err != nil {
+			return fmt.Errorf("'%s' isn't a command or a roll - %v", line, err)
+		}
+		result := roll.Simulate()
+		p.AddRoll(result, party.PublicRoll)
+		ui.message = fmt.Sprintf("Rolled %s: %s = %d", roll, result.StringIndividualRolls(),
+			result.Sum)
+	}
+	if err != nil {
+		return err
+	}
+	return p.Save()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"dnd/creature"
+	"dnd/dice"
+	"dnd/party"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHPBar(t *testing.T) {
+	c := creature.Create("orc", "grom", &dice.Roll{Offset: 10})
+	assert.Contains(t, hpBar(c), "["+strings.Repeat("#", hpBarWidth)+"]")
+	c.DamageTaken = 6
+	assert.Contains(t, hpBar(c), healthColours["damaged"]+"[########------------]")
+	c.DamageTaken = 12
+	assert.Contains(t, hpBar(c), healthColours["dead"]+"["+strings.Repeat("-", hpBarWidth)+"]")
+}
+
+func TestTerminalUI(t *testing.T) {
+	d, err := ioutil.TempDir("", "tui")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	store := party.NewFileStore(d)
+	assert.NoError(t, store.Save(party.New("", "heroes")))
+	p, _ := store.Load("heroes")
+	c := creature.Create("orc", "grom", &dice.Roll{Offset: 15})
+	p.Apply(&party.AddCreatureAction{c})
+
+	var out bytes.Buffer
+	input := "d " + c.ID + " 10\n3\n1d99999999999999999999\nd nobody 1\nu\nr\nq\nd " + c.ID + " 1\n"
+	assert.NoError(t, (&terminalUI{party: p, out: &out}).run(strings.NewReader(input)))
+	assert.Contains(t, out.String(), "5/15 HP")
+	assert.Contains(t, out.String(), "Rolled 3")
+	assert.Contains(t, out.String(), "Error: ")
+
+	// Everything up to quitting was saved
+	saved, err := store.Load("heroes")
+	assert.NoError(t, err)
+	assert.Equal(t, 10, saved.Creatures()[0].DamageTaken)
+	assert.Len(t, saved.Rolls(), 1)
+}
+
+func TestTerminalUIKeys(t *testing.T) {
+	p := party.New("", "heroes")
+	assert.NoError(t, party.NewMemoryStore().Save(p))
+	c := creature.Create("orc", "grom", &dice.Roll{Offset: 15})
+	p.Apply(&party.AddCreatureAction{c})
+
+	var out bytes.Buffer
+	// Escape cancels, and backspace takes back what was typed
+	input := "d" + c.ID + " 10\n" + "d" + c.ID + " 3\x1b" + "4\x7f3\nxq"
+	ui := &terminalUI{party: p, out: &out, keys: true}
+	assert.NoError(t, ui.runKeys(strings.NewReader(input)))
+	assert.Contains(t, out.String(), "5/15 HP")
+	assert.Contains(t, out.String(), "Rolled 3")
+	assert.Contains(t, out.String(), tuiKeysHelp)
+	assert.Equal(t, 10, p.Creature(c.ID).DamageTaken)
+	assert.Len(t, p.Rolls(), 1)
+}
@@ -1,6 +1,10 @@
 package undobuffer
 
-import "fmt"
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+)
 
 // Buffer is a stack of fixed size, stored under the hood using a circular buffer.
 // If the Buffer is full, the oldest value will be overwritten.
@@ -83,6 +87,44 @@ func (b *Buffer) CanUnpop() bool {
 	return b.canUnpop
 }
 
+// bufferGob mirrors Buffer with exported fields so that gob can see them
+type bufferGob struct {
+	Data                                 []interface{}
+	LowestValidElement, NextSpace, Limit int
+	IsFull, CanUnpop                     bool
+}
+
+// GobEncode encodes the whole state of the buffer. Anything stored in the buffer needs to be
+// registered with gob.Register for this to work.
+func (b *Buffer) GobEncode() ([]byte, error) {
+	var encoded bytes.Buffer
+	err := gob.NewEncoder(&encoded).Encode(bufferGob{
+		b.data, b.lowestValidElement, b.nextSpace, b.limit, b.isFull, b.canUnpop})
+	if err != nil {
+		return nil, fmt.Errorf("error encoding buffer - %v", err)
+	}
+	return encoded.Bytes(), nil
+}
+
+// GobDecode restores a buffer encoded with GobEncode
+func (b *Buffer) GobDecode(encoded []byte) error {
+	var decoded bufferGob
+	err := gob.NewDecoder(bytes.NewReader(encoded)).Decode(&decoded)
+	if err != nil {
+		return fmt.Errorf("error decoding buffer - %v", err)
+	}
+	if len(decoded.Data) == 0 {
+		return fmt.Errorf("decoded buffer has no space")
+	}
+	b.data = decoded.Data
+	b.lowestValidElement = decoded.LowestValidElement
+	b.nextSpace = decoded.NextSpace
+	b.limit = decoded.Limit
+	b.isFull = decoded.IsFull
+	b.canUnpop = decoded.CanUnpop
+	return nil
+}
+
 /* The difficult case is when lowestValidElement is above nextSpace
 
     |<-------------len(b.data)---------------->|
@@ -1,6 +1,8 @@
 package undobuffer
 
 import (
+	"bytes"
+	"encoding/gob"
 	"fmt"
 	"testing"
 
@@ -116,3 +118,38 @@ func TestWrapOverTwice(t *testing.T) {
 	_, err = b.Unpop()
 	assert.Error(t, err)
 }
+
+func TestGobRoundTrip(t *testing.T) {
+	b := NewBuffer(4)
+	for i := 0; i < 6; i++ {
+		b.Push(i)
+	}
+	b.Pop()
+	var encoded bytes.Buffer
+	err := gob.NewEncoder(&encoded).Encode(b)
+	assert.NoError(t, err)
+	var decoded Buffer
+	err = gob.NewDecoder(&encoded).Decode(&decoded)
+	assert.NoError(t, err)
+	assert.Equal(t, b.Len(), decoded.Len())
+	el, err := decoded.Unpop()
+	assert.NoError(t, err)
+	assert.Equal(t, 5, el)
+	el, err = decoded.Pop()
+	assert.NoError(t, err)
+	assert.Equal(t, 5, el)
+}
+
+func TestGobPartlyFilledBuffer(t *testing.T) {
+	b := NewBuffer(8)
+	b.Push("only")
+	var encoded bytes.Buffer
+	err := gob.NewEncoder(&encoded).Encode(b)
+	assert.NoError(t, err)
+	var decoded Buffer
+	err = gob.NewDecoder(&encoded).Decode(&decoded)
+	assert.NoError(t, err)
+	el, err := decoded.Peek()
+	assert.NoError(t, err)
+	assert.Equal(t, "only", el)
+}
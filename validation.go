@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+)
+
+// ValidationError is a problem with something typed into a form. Field is the name of the input
+// it's about, so the message can go next to it, or empty if it's about the whole form.
+type ValidationError struct {
+	Field, Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+func invalid(field, format string, a ...interface{}) *ValidationError {
+	return &ValidationError{field, fmt.Sprintf(format, a...)}
+}
+
+// flash is what a post that went wrong leaves for the page it redirects to: the errors, by the
+// field they're about, and what was typed into the form, so it can be put back. Page is the path
+// of the page it's for, so that no other page shows it, e.g. in another tab.
+type flash struct {
+	Errors map[string]string `json:"errors"`
+	Values map[string]string `json:"values"`
+	Page   string            `json:"page"`
+}
+
+const flashCookie = "flash"
+
+// maxFlashValue is the longest typed in value that's put back, to keep the cookie small
+const maxFlashValue = 200
+
+// setFlash keeps the error from a post in a cookie, for the page it redirects to
+func setFlash(w http.ResponseWriter, r *http.Request, err error, redirectURI string) {
+	// The page is kept escaped, as that's how browsers match a cookie's path, and how the party
+	// prefix of a request is
+	page := "/"
+	if u, err := url.Parse(redirectURI); err == nil && u.Path != "" {
+		page = u.EscapedPath()
+	}
+	f := &flash{map[string]string{}, map[string]string{}, page}
+	if v, ok := err.(*ValidationError); ok {
+		f.Errors[v.Field] = v.Message
+	} else {
+		f.Errors[""] = err.Error()
+	}
+	for field, values := range r.PostForm {
+		if field == "redirectURI" || field == csrfTokenField || len(values) != 1 ||
+			len(values[0]) > maxFlashValue {
+			continue
+		}
+		f.Values[field] = values[0]
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		return
+	}
+	setFlashCookie(w, page, base64.URLEncoding.EncodeToString(data), 60)
+}
+
+func setFlashCookie(w http.ResponseWriter, page, value string, age int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     flashCookie,
+		Value:    value,
+		Path:     page,
+		MaxAge:   age,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// takeFlash gets the flash left for a page, and clears it so it's only shown once. The cookie is
+// also sent to the pages under the one it's for, which leave it alone.
+func takeFlash(w http.ResponseWriter, r *http.Request) *flash {
+	cookie, err := r.Cookie(flashCookie)
+	if err != nil {
+		return &flash{}
+	}
+	data, err := base64.URLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return &flash{}
+	}
+	f := &flash{}
+	json.Unmarshal(data, f)
+	page := partyPrefix(r) + r.URL.EscapedPath()
+	if f.Page != page {
+		return &flash{}
+	}
+	setFlashCookie(w, page, "", -1)
+	return f
+}
+
+// funcs are the template functions for showing a flash. fieldValue is what was typed into a
+// field, or the default if there's nothing to put back, fieldError is the error for a field, and
+// formError is the error for the whole form.
+func (f *flash) funcs() template.FuncMap {
+	return template.FuncMap{
+		"fieldValue": func(field, value string) string {
+			if typed, ok := f.Values[field]; ok {
+				return typed
+			}
+			return value
+		},
+		"fieldError": func(field string) string {
+			return f.Errors[field]
+		},
+		"formError": func() string {
+			return f.Errors[""]
+		}}
+}